@@ -0,0 +1,296 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Pungyeon/docker-gompose/compose"
+	"github.com/Pungyeon/docker-gompose/server"
+	"github.com/docker/docker/pkg/term"
+)
+
+// ExitError makes the CLI exit with the exit code of a command run in a
+// service, without printing an error.
+type ExitError struct {
+	Code int
+}
+
+func (err ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", err.Code)
+}
+
+// stringList is a flag that may be given multiple times.
+type stringList []string
+
+func (list *stringList) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *stringList) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
+
+func execService(ctx *Context, args []string) error {
+	if len(args) < 2 {
+		return usageErrorf("exec requires a service and a command")
+	}
+	return attachService(ctx, "/exec", args[0], args[1:])
+}
+
+func runService(ctx *Context, args []string) error {
+	if len(args) < 1 {
+		return usageErrorf("run requires a service")
+	}
+	return attachService(ctx, "/run", args[0], args[1:])
+}
+
+func attachToService(ctx *Context, args []string) error {
+	if len(args) != 1 {
+		return usageErrorf("attach requires exactly one service")
+	}
+	return attachService(ctx, "/attach", args[0], nil)
+}
+
+func attachService(ctx *Context, path, name string, cmd []string) error {
+	data, definition, err := loadInlineDefinition(ctx)
+	if err != nil {
+		return err
+	}
+	service, ok := definition.Services[name]
+	if !ok {
+		return fmt.Errorf("no such service: %v", name)
+	}
+	_, isTerm := term.GetFdInfo(os.Stdin)
+	// the server cannot allocate a terminal for EXEC services
+	tty := isTerm && !ctx.NoTTY && compose.DriverFromString(service.Driver) != compose.EXEC
+	stdin := true
+	if path == "/attach" {
+		// the terminal and stdin of the service are given by its definition
+		tty = isTerm && service.Tty
+		stdin = service.StdinOpen && !ctx.NoStdin
+		if tty {
+			fmt.Fprintf(os.Stderr, "attached to %s, detach with ctrl-p ctrl-q\n", name)
+		}
+	}
+	options := compose.ExecOptions{
+		Cmd:        cmd,
+		Tty:        tty,
+		Stdin:      stdin,
+		Env:        ctx.Env,
+		User:       ctx.User,
+		WorkingDir: ctx.WorkDir,
+		Remove:     ctx.Remove,
+		Entrypoint: ctx.Entrypoint,
+		Volumes:    ctx.Volumes,
+	}
+
+	restore, resize, err := setupTerminal(tty)
+	if err != nil {
+		return err
+	}
+	var code int
+	if ctx.Standalone {
+		code, err = attachLocal(ctx, path, definition, name, options, resize)
+	} else {
+		code, err = attachRemote(ctx, path, data, name, options, resize)
+	}
+	restore()
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return ExitError{Code: code}
+	}
+	return nil
+}
+
+func attachLocal(ctx *Context, path string, definition compose.Definition, name string, options compose.ExecOptions, resize <-chan compose.TerminalSize) (int, error) {
+	app, err := openLocal(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var attach func(context.Context, compose.Definition, string, compose.ExecOptions, compose.Streams) (int, error)
+	switch path {
+	case "/run":
+		attach = app.RunOnce
+	case "/attach":
+		attach = app.Attach
+	default:
+		attach = app.Exec
+	}
+	code, err := attach(context.Background(), definition, name, options, compose.Streams{
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+		Resize: resize,
+	})
+	if shutdownErr := app.Shutdown(false); err == nil {
+		err = shutdownErr
+	}
+	return code, err
+}
+
+// attachRemote upgrades a request to the server to a stream of frames,
+// forwarding stdin and terminal resizes until the command exits.
+func attachRemote(ctx *Context, path string, data []byte, name string, options compose.ExecOptions, resize <-chan compose.TerminalSize) (int, error) {
+	query := url.Values{}
+	query.Set("service", name)
+	query.Set("tty", strconv.FormatBool(options.Tty))
+	query.Set("stdin", strconv.FormatBool(options.Stdin))
+	query.Set("rm", strconv.FormatBool(options.Remove))
+	query.Set("user", options.User)
+	query.Set("workdir", options.WorkingDir)
+	query.Set("entrypoint", options.Entrypoint)
+	query["arg"] = options.Cmd
+	query["env"] = options.Env
+	query["volume"] = options.Volumes
+
+	conn, err := ctx.dial()
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	req, err := ctx.newRequest("POST", path, query, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", server.UpgradeProtocol)
+	if err := req.Write(conn); err != nil {
+		return 0, err
+	}
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return 0, err
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		defer res.Body.Close()
+		return 0, checkResponse(res)
+	}
+
+	mu := &sync.Mutex{}
+	send := func(kind byte, payload []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return server.WriteFrame(conn, kind, payload)
+	}
+	if options.Stdin {
+		go func() {
+			buf := make([]byte, 32*1024)
+			for {
+				n, err := os.Stdin.Read(buf)
+				if n > 0 {
+					if send(server.FrameStdin, buf[:n]) != nil {
+						return
+					}
+				}
+				if err != nil {
+					send(server.FrameStdinClose, nil)
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		for size := range resize {
+			send(server.FrameResize, server.EncodeResize(size))
+		}
+	}()
+
+	for {
+		kind, payload, err := server.ReadFrame(reader)
+		if err != nil {
+			if err == io.EOF {
+				return 0, fmt.Errorf("connection to the server closed unexpectedly")
+			}
+			return 0, err
+		}
+		switch kind {
+		case server.FrameStdout:
+			os.Stdout.Write(payload)
+		case server.FrameStderr:
+			os.Stderr.Write(payload)
+		case server.FrameExit:
+			return strconv.Atoi(string(payload))
+		case server.FrameError:
+			return 0, errors.New(string(payload))
+		}
+	}
+}
+
+// dial connects to the server, over TLS when it is enabled.
+func (ctx *Context) dial() (net.Conn, error) {
+	network, address, err := server.ParseHost(ctx.Host)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout(network, address, time.Second*5)
+	if err != nil {
+		return nil, err
+	}
+	if network != "tcp" || !ctx.secure() {
+		return conn, nil
+	}
+	config, err := server.ClientTLSConfig(ctx.TLSCA, ctx.TLSCert, ctx.TLSKey)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		config.ServerName = host
+	}
+	return tls.Client(conn, config), nil
+}
+
+// setupTerminal puts the terminal in raw mode when a terminal is allocated,
+// and reports its size whenever it changes. The returned function restores
+// the terminal.
+func setupTerminal(tty bool) (func(), <-chan compose.TerminalSize, error) {
+	if !tty {
+		return func() {}, nil, nil
+	}
+	in, _ := term.GetFdInfo(os.Stdin)
+	out, _ := term.GetFdInfo(os.Stdout)
+	state, err := term.SetRawTerminal(in)
+	if err != nil {
+		return nil, nil, err
+	}
+	sizes := make(chan compose.TerminalSize, 1)
+	signals := make(chan os.Signal, 1)
+	notifyResize(signals)
+	go func() {
+		defer close(sizes)
+		for {
+			if size, err := term.GetWinsize(out); err == nil {
+				select {
+				case sizes <- compose.TerminalSize{Height: uint(size.Height), Width: uint(size.Width)}:
+				default:
+				}
+			}
+			if _, ok := <-signals; !ok {
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(signals)
+		term.RestoreTerminal(in, state)
+	}, sizes, nil
+}
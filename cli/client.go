@@ -0,0 +1,285 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Pungyeon/docker-gompose/compose"
+	"github.com/Pungyeon/docker-gompose/server"
+	"github.com/docker/docker/pkg/term"
+)
+
+func (ctx *Context) url(path string, query url.Values) string {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("project", ctx.Project)
+	base := strings.TrimRight(ctx.Host, "/")
+	if network, address, err := server.ParseHost(ctx.Host); err == nil {
+		// the host of unix sockets is ignored, as the client dials the socket
+		if network == "unix" {
+			address = "gompose"
+		}
+		scheme := "http://"
+		if network == "tcp" && ctx.secure() {
+			scheme = "https://"
+		}
+		base = scheme + address
+	}
+	return base + path + "?" + query.Encode()
+}
+
+// secure reports whether TLS is used to connect to the server, which is the
+// case for https:// hosts, or when any TLS flag is given.
+func (ctx *Context) secure() bool {
+	return strings.HasPrefix(ctx.Host, "https://") || ctx.TLSCA != "" || ctx.TLSCert != ""
+}
+
+// client returns an http client connecting to the server at the given host,
+// whether it is listening on a unix socket or a TCP address.
+func (ctx *Context) client() (*http.Client, error) {
+	network, address, err := server.ParseHost(ctx.Host)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: time.Second * 5}
+	transport := &http.Transport{
+		DialContext: func(c context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(c, network, address)
+		},
+	}
+	if network == "tcp" && ctx.secure() {
+		config, err := server.ClientTLSConfig(ctx.TLSCA, ctx.TLSCert, ctx.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = config
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+func (ctx *Context) newRequest(method, path string, query url.Values, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, ctx.url(path, query), body)
+	if err != nil {
+		return nil, err
+	}
+	if ctx.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+ctx.Token)
+	}
+	return req, nil
+}
+
+func (ctx *Context) get(path string, query url.Values) (*http.Response, error) {
+	client, err := ctx.client()
+	if err != nil {
+		return nil, err
+	}
+	req, err := ctx.newRequest("GET", path, query, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(res); err != nil {
+		res.Body.Close()
+		return nil, err
+	}
+	return res, nil
+}
+
+func checkResponse(res *http.Response) error {
+	if res.StatusCode == http.StatusOK {
+		return nil
+	}
+	msg, _ := ioutil.ReadAll(res.Body)
+	return fmt.Errorf("server returned %s: %s", res.Status, bytes.TrimSpace(msg))
+}
+
+// RunCommand sends the definition to the server, rendering the progress of
+// the command as it is streamed back.
+func RunCommand(ctx *Context, cmd string) error {
+	data, definition, err := loadInlineDefinition(ctx)
+	if err != nil {
+		return err
+	}
+	if err := definition.Validate(); err != nil {
+		return err
+	}
+	query := url.Values{}
+	query.Set("cmd", cmd)
+	if ctx.Format != "" {
+		query.Set("format", ctx.Format)
+	}
+	if ctx.Timeout.set {
+		query.Set("timeout", ctx.Timeout.String())
+	}
+	if ctx.RemoveVolumes {
+		query.Set("volumes", "true")
+	}
+	if ctx.RemoveOrphans {
+		query.Set("remove-orphans", "true")
+	}
+	if ctx.RemoveImages != "" {
+		query.Set("rmi", ctx.RemoveImages)
+	}
+	query["profile"] = ctx.profiles()
+	req, err := ctx.newRequest("POST", "/config", query, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/x-ndjson")
+	client, err := ctx.client()
+	if err != nil {
+		return err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := checkResponse(res); err != nil {
+		return err
+	}
+
+	renderer := ctx.renderer()
+	decoder := json.NewDecoder(res.Body)
+	for {
+		var msg server.StreamMessage
+		if err := decoder.Decode(&msg); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err := ctx.render(renderer, msg); err != nil {
+			return err
+		}
+	}
+}
+
+func (ctx *Context) renderer() *Renderer {
+	_, isTerm := term.GetFdInfo(os.Stdout)
+	return NewRenderer(os.Stdout, isTerm && ctx.Format != "json")
+}
+
+// render displays a single message streamed by a command, returning the
+// error of the command, if it failed.
+func (ctx *Context) render(renderer *Renderer, msg server.StreamMessage) error {
+	switch {
+	case msg.Event != nil:
+		if ctx.Format != "json" {
+			renderer.Event(*msg.Event)
+		}
+	case msg.Error != "":
+		return errors.New(msg.Error)
+	default:
+		renderer.Output(msg.Output)
+	}
+	return nil
+}
+
+// FollowEvents prints every lifecycle event published by the server, until
+// the connection is closed.
+func FollowEvents(ctx *Context) error {
+	res, err := ctx.get("/events", nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var event compose.LifecycleEvent
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+			return err
+		}
+		fmt.Printf("%s %-18s %-15s %s %s\n", event.Time.Format(time.RFC3339), event.Type,
+			event.Service, event.ID, event.Message)
+	}
+	return scanner.Err()
+}
+
+func StreamLogs(ctx *Context, service string) error {
+	query := url.Values{}
+	query.Set("service", service)
+	query.Set("follow", fmt.Sprintf("%t", ctx.Follow))
+	query.Set("tail", ctx.Tail)
+	query.Set("show-secrets", fmt.Sprintf("%t", ctx.ShowSecrets))
+	res, err := ctx.get("/logs", query)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, err = io.Copy(os.Stdout, res.Body)
+	return err
+}
+
+func GetStatus(ctx *Context) (server.Status, error) {
+	res, err := ctx.get("/status", nil)
+	if err != nil {
+		return server.Status{}, err
+	}
+	defer res.Body.Close()
+	var status server.Status
+	if err := json.NewDecoder(res.Body).Decode(&status); err != nil {
+		return server.Status{}, err
+	}
+	return status, nil
+}
+
+// StopServer asks the server to shut down, and waits until it has stopped
+// accepting connections.
+func StopServer(ctx *Context) error {
+	client, err := ctx.client()
+	if err != nil {
+		return err
+	}
+	query := url.Values{}
+	query.Set("stop", fmt.Sprintf("%t", ctx.StopServices))
+	req, err := ctx.newRequest("POST", "/shutdown", query, nil)
+	if err != nil {
+		return err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusAccepted {
+		return checkResponse(res)
+	}
+
+	deadline := time.Now().Add(time.Minute)
+	for time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 200)
+		req, err := ctx.newRequest("GET", "/status", nil, nil)
+		if err != nil {
+			return err
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			fmt.Println("server stopped")
+			return nil
+		}
+		res.Body.Close()
+	}
+	return fmt.Errorf("timed out waiting for the server to stop")
+}
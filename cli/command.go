@@ -0,0 +1,268 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Pungyeon/docker-gompose/compose"
+	"github.com/Pungyeon/docker-gompose/server"
+)
+
+// StandaloneEnv enables the standalone mode when set to a true value.
+const StandaloneEnv = "GOMPOSE_STANDALONE"
+
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+// Context holds the values of the flags given on the command line.
+type Context struct {
+	Host    string
+	File    string
+	Project string
+	Format  string
+	Timeout optionalDuration
+	Follow  bool
+	Tail    string
+	Store   string
+	Listen  string
+	Socket  string
+
+	Profiles stringList
+
+	RemoveVolumes bool
+	RemoveOrphans bool
+	RemoveImages  string
+
+	ShowSecrets bool
+
+	Standalone bool
+
+	Token       string
+	TLSCA       string
+	TLSCert     string
+	TLSKey      string
+	Auth        bool
+	Insecure    bool
+	TokenFile   string
+	DisableExec bool
+
+	StopServices bool
+
+	Detach     bool
+	PidFile    string
+	LogFile    string
+	LogStderr  bool
+	LogMaxSize int
+	LogBackups int
+	UserUnit   bool
+
+	NoTTY      bool
+	Env        stringList
+	User       string
+	WorkDir    string
+	Remove     bool
+	Entrypoint string
+	Volumes    stringList
+	NoStdin    bool
+}
+
+// Command is a node in the command tree. Commands either run an action or
+// dispatch to one of their sub commands.
+type Command struct {
+	Name     string
+	Usage    string
+	Short    string
+	Flags    func(flags *flag.FlagSet, ctx *Context)
+	Run      func(ctx *Context, args []string) error
+	Commands []*Command
+	// Hidden commands are left out of the help and the completions.
+	Hidden bool
+}
+
+type UsageError struct {
+	msg string
+}
+
+func (err UsageError) Error() string {
+	return err.msg
+}
+
+func usageErrorf(format string, args ...interface{}) error {
+	return UsageError{msg: fmt.Sprintf(format, args...)}
+}
+
+// Execute runs the command line and returns the exit code of the program.
+func Execute(args []string) int {
+	ctx := &Context{}
+	err := root().execute(ctx, nil, args)
+	if err == nil || err == flag.ErrHelp {
+		return exitOK
+	}
+	var exit ExitError
+	if errors.As(err, &exit) {
+		return exit.Code
+	}
+	fmt.Fprintln(os.Stderr, "error:", err)
+	var usage UsageError
+	if errors.As(err, &usage) {
+		return exitUsage
+	}
+	return exitError
+}
+
+func (cmd *Command) execute(ctx *Context, parents []string, args []string) error {
+	path := append(parents, cmd.Name)
+	flags := flag.NewFlagSet(strings.Join(path, " "), flag.ContinueOnError)
+	flags.SetOutput(os.Stderr)
+	globalFlags(flags, ctx)
+	if cmd.Flags != nil {
+		cmd.Flags(flags, ctx)
+	}
+	flags.Usage = func() {
+		cmd.printHelp(os.Stderr, path, flags)
+	}
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return err
+		}
+		return UsageError{msg: err.Error()}
+	}
+	args = flags.Args()
+
+	if len(cmd.Commands) == 0 {
+		return cmd.Run(ctx, args)
+	}
+	if len(args) == 0 {
+		if cmd.Run != nil {
+			return cmd.Run(ctx, args)
+		}
+		flags.Usage()
+		return usageErrorf("%s requires a command", strings.Join(path, " "))
+	}
+	if args[0] == "help" {
+		flags.Usage()
+		return nil
+	}
+	for _, sub := range cmd.Commands {
+		if sub.Name == args[0] {
+			return sub.execute(ctx, path, args[1:])
+		}
+	}
+	flags.Usage()
+	return usageErrorf("unknown command: %s", strings.Join(append(path, args[0]), " "))
+}
+
+func (cmd *Command) printHelp(w io.Writer, path []string, flags *flag.FlagSet) {
+	usage := strings.Join(path, " ")
+	if len(cmd.Commands) != 0 {
+		usage += " COMMAND"
+	}
+	if cmd.Usage != "" {
+		usage += " " + cmd.Usage
+	}
+	fmt.Fprintf(w, "Usage: %s\n", usage)
+	if cmd.Short != "" {
+		fmt.Fprintf(w, "\n%s\n", cmd.Short)
+	}
+	if len(cmd.Commands) != 0 {
+		fmt.Fprintf(w, "\nCommands:\n")
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		for _, sub := range cmd.Commands {
+			if !sub.Hidden {
+				fmt.Fprintf(tw, "  %s\t%s\n", sub.Name, sub.Short)
+			}
+		}
+		tw.Flush()
+	}
+	fmt.Fprintf(w, "\nFlags:\n")
+	flags.PrintDefaults()
+}
+
+func globalFlags(flags *flag.FlagSet, ctx *Context) {
+	flags.StringVar(&ctx.Host, "host", stringOr(ctx.Host, server.DefaultHost()),
+		"address of the gompose server, as unix:///path or tcp://host:port (env: "+server.HostEnv+")")
+	flags.StringVar(&ctx.File, "f", stringOr(ctx.File, "config.yaml"), "path to the definition file")
+	flags.StringVar(&ctx.Project, "p", stringOr(ctx.Project, compose.DefaultProject), "project name")
+	flags.BoolVar(&ctx.Standalone, "standalone", ctx.Standalone || envBool(StandaloneEnv),
+		"run the command in this process rather than on a server, which must not be running for the project (env: "+StandaloneEnv+")")
+	flags.Var(&ctx.Profiles, "profile", "enable the services of the profile, may be repeated (env: "+compose.ProfilesEnv+")")
+	flags.StringVar(&ctx.Store, "store", stringOr(ctx.Store, "json"), "state store backend: json, bolt or memory")
+	flags.StringVar(&ctx.Token, "token", stringOr(ctx.Token, os.Getenv(server.TokenEnv)),
+		"bearer token to authenticate with (env: "+server.TokenEnv+")")
+	flags.StringVar(&ctx.TLSCA, "tls-ca", ctx.TLSCA,
+		"CA certificate verifying the server, or the clients when starting the server")
+	flags.StringVar(&ctx.TLSCert, "tls-cert", ctx.TLSCert, "TLS certificate presented to the other end")
+	flags.StringVar(&ctx.TLSKey, "tls-key", ctx.TLSKey, "key of the TLS certificate")
+}
+
+// profiles returns the profiles given on the command line, or else those in
+// the environment. Each may be a comma separated list.
+func (ctx *Context) profiles() []string {
+	if len(ctx.Profiles) == 0 {
+		return compose.ParseProfiles(os.Getenv(compose.ProfilesEnv))
+	}
+	var profiles []string
+	for _, value := range ctx.Profiles {
+		profiles = append(profiles, compose.ParseProfiles(value)...)
+	}
+	return profiles
+}
+
+func envBool(name string) bool {
+	value, _ := strconv.ParseBool(os.Getenv(name))
+	return value
+}
+
+func stringOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// optionalDuration is a duration flag telling whether it was given, so that
+// zero can be told apart from the default. A bare integer is a number of
+// seconds, as with docker-compose.
+type optionalDuration struct {
+	value time.Duration
+	set   bool
+}
+
+func (d *optionalDuration) String() string {
+	if d == nil || !d.set {
+		return ""
+	}
+	return d.value.String()
+}
+
+func (d *optionalDuration) Set(value string) error {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		d.value, d.set = time.Duration(seconds)*time.Second, true
+		return nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	d.value, d.set = duration, true
+	return nil
+}
+
+// duration returns the value of the flag, or nil when it wasn't given.
+func (d optionalDuration) duration() *time.Duration {
+	if !d.set {
+		return nil
+	}
+	value := d.value
+	return &value
+}
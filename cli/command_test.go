@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOptionalDurationSet(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		err   bool
+	}{
+		{value: "10", want: 10 * time.Second},
+		{value: "0", want: 0},
+		{value: "1m30s", want: 90 * time.Second},
+		{value: "500ms", want: 500 * time.Millisecond},
+		{value: "-5", want: -5 * time.Second},
+		{value: "soon", err: true},
+		{value: "", err: true},
+	}
+	for _, test := range tests {
+		var d optionalDuration
+		err := d.Set(test.value)
+		if test.err {
+			if err == nil {
+				t.Errorf("Set(%q) = %v, expected an error", test.value, d.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Set(%q) returned an error: %v", test.value, err)
+			continue
+		}
+		if got := d.duration(); got == nil || *got != test.want {
+			t.Errorf("Set(%q) = %v, expected %v", test.value, got, test.want)
+		}
+	}
+}
+
+func TestCliAlias(t *testing.T) {
+	var alias *Command
+	for _, cmd := range root().Commands {
+		if cmd.Name == "cli" {
+			alias = cmd
+		}
+	}
+	if alias == nil || !alias.Hidden {
+		t.Fatalf("expected a hidden cli command, got %+v", alias)
+	}
+	names := map[string]bool{}
+	for _, cmd := range alias.Commands {
+		names[cmd.Name] = true
+	}
+	for _, name := range []string{"up", "down", "ps", "events"} {
+		if !names[name] {
+			t.Errorf("cli %s is not an alias of %s", name, name)
+		}
+	}
+	if names["server"] || names["cli"] {
+		t.Errorf("unexpected commands under cli: %v", names)
+	}
+	words := map[string][]string{}
+	completions(root(), "gompose", words)
+	for _, word := range words["gompose"] {
+		if word == "cli" {
+			t.Errorf("the hidden cli command is completed")
+		}
+	}
+}
@@ -0,0 +1,413 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/Pungyeon/docker-gompose/compose"
+	"github.com/Pungyeon/docker-gompose/server"
+	"gopkg.in/yaml.v2"
+)
+
+func root() *Command {
+	cmd := &Command{
+		Name:  "gompose",
+		Short: "Run docker containers and local executables from a single definition.",
+		Commands: []*Command{
+			{
+				Name:  "server",
+				Short: "Manage the gompose server",
+				Commands: []*Command{
+					{
+						Name:  "start",
+						Short: "Start an instance of the gompose server",
+						Flags: func(flags *flag.FlagSet, ctx *Context) {
+							flags.StringVar(&ctx.Listen, "listen", "", "TCP address to listen on, e.g. localhost:8080")
+							flags.StringVar(&ctx.Socket, "socket", server.DefaultSocketPath(), "unix socket to listen on, empty to disable")
+							flags.BoolVar(&ctx.Auth, "auth", false, "require a bearer token on requests over TCP")
+							flags.BoolVar(&ctx.Insecure, "insecure", false, "allow listening on TCP without --auth or client certificates (--tls-ca)")
+							flags.BoolVar(&ctx.DisableExec, "disable-exec", false, "refuse to run services using the EXEC driver")
+							flags.BoolVar(&ctx.StopServices, "stop-on-exit", false, "stop all services when the server shuts down")
+							flags.BoolVar(&ctx.Detach, "detach", false, "run the server in the background")
+							flags.StringVar(&ctx.PidFile, "pidfile", "", "file to write the pid of the server to (default "+defaultPidFile+" when detached)")
+							flags.StringVar(&ctx.LogFile, "log-file", "", "file to log to instead of stderr (default "+defaultLogFile+" when detached)")
+							flags.IntVar(&ctx.LogMaxSize, "log-max-size", 10, "size in megabytes at which the log file is rotated")
+							flags.IntVar(&ctx.LogBackups, "log-backups", 3, "number of rotated log files to keep")
+							flags.BoolVar(&ctx.LogStderr, "log-stderr", false, "also write stderr, including panics, to the log file (default true when detached)")
+							tokenFileFlag(flags, ctx)
+						},
+						Run: noArgs(startServer),
+					},
+					{
+						Name:  "stop",
+						Short: "Shut down the running gompose server, saving its state",
+						Flags: func(flags *flag.FlagSet, ctx *Context) {
+							flags.BoolVar(&ctx.StopServices, "services", false, "stop all services before shutting down")
+						},
+						Run: noArgs(StopServer),
+					},
+					{
+						Name:  "status",
+						Short: "Show the status of the running gompose server",
+						Run:   noArgs(serverStatus),
+					},
+					{
+						Name:  "systemd",
+						Usage: "[-- START FLAGS...]",
+						Short: "Print a systemd unit running the server with the given flags",
+						Flags: func(flags *flag.FlagSet, ctx *Context) {
+							flags.BoolVar(&ctx.UserUnit, "user", false, "generate a unit for the systemd user instance")
+						},
+						Run: systemdUnit,
+					},
+					{
+						Name:  "token",
+						Short: "Manage the bearer tokens accepted by the server",
+						Flags: tokenFileFlag,
+						Commands: []*Command{
+							{
+								Name:  "create",
+								Usage: "NAME",
+								Short: "Create a token and print it",
+								Flags: tokenFileFlag,
+								Run:   createToken,
+							},
+							{
+								Name:  "ls",
+								Short: "List the tokens",
+								Flags: tokenFileFlag,
+								Run:   noArgs(listTokens),
+							},
+							{
+								Name:  "revoke",
+								Usage: "NAME",
+								Short: "Revoke a token",
+								Flags: tokenFileFlag,
+								Run:   revokeToken,
+							},
+						},
+					},
+				},
+			},
+			{
+				Name:  "up",
+				Short: "Create and start the containers and executables in the definition",
+				Run:   noArgs(dispatch("start")),
+			},
+			{
+				Name:  "down",
+				Short: "Stop and remove all containers, executables and networks",
+				Flags: func(flags *flag.FlagSet, ctx *Context) {
+					timeoutFlag(flags, ctx)
+					flags.BoolVar(&ctx.RemoveVolumes, "volumes", false, "also remove the volumes, except external volumes")
+					flags.BoolVar(&ctx.RemoveOrphans, "remove-orphans", false, "remove containers of services no longer in the definition")
+					flags.StringVar(&ctx.RemoveImages, "rmi", "", "remove images used by the services: local or all")
+				},
+				Run: noArgs(dispatch("rm")),
+			},
+			{
+				Name:  "stop",
+				Short: "Stop all running containers and executables",
+				Flags: timeoutFlag,
+				Run:   noArgs(dispatch("stop")),
+			},
+			{
+				Name:  "restart",
+				Short: "Stop and start all containers and executables",
+				Flags: timeoutFlag,
+				Run:   noArgs(dispatch("restart")),
+			},
+			{
+				Name:  "ps",
+				Short: "List containers and executables",
+				Flags: formatFlag,
+				Run:   noArgs(dispatch("ps")),
+			},
+			{
+				Name:  "images",
+				Short: "List the image and digest used by each container",
+				Flags: formatFlag,
+				Run:   noArgs(dispatch("images")),
+			},
+			{
+				Name:  "pin",
+				Short: "Pin the resolved image digests into the lock file",
+				Run:   noArgs(dispatch("pin")),
+			},
+			{
+				Name:  "history",
+				Short: "List the most recent operations, when using the bolt state store",
+				Run:   noArgs(dispatch("history")),
+			},
+			{
+				Name:  "logs",
+				Usage: "SERVICE",
+				Short: "Show the output of a service",
+				Flags: func(flags *flag.FlagSet, ctx *Context) {
+					flags.BoolVar(&ctx.Follow, "follow", false, "follow the log output")
+					flags.StringVar(&ctx.Tail, "tail", "all", "number of lines to show from the end of the logs")
+					showSecretsFlag(flags, ctx)
+				},
+				Run: serviceLogs,
+			},
+			{
+				Name:  "exec",
+				Usage: "SERVICE COMMAND [ARGS...]",
+				Short: "Run a command in a running service",
+				Flags: attachFlags,
+				Run:   execService,
+			},
+			{
+				Name:  "run",
+				Usage: "SERVICE [COMMAND [ARGS...]]",
+				Short: "Run a command in a new container created from a service",
+				Flags: func(flags *flag.FlagSet, ctx *Context) {
+					attachFlags(flags, ctx)
+					flags.BoolVar(&ctx.Remove, "rm", false, "remove the container when the command exits")
+					flags.StringVar(&ctx.Entrypoint, "entrypoint", "", "override the entrypoint of the service")
+					flags.Var(&ctx.Volumes, "v", "bind mount a host path as host:container[:ro], may be repeated")
+				},
+				Run: runService,
+			},
+			{
+				Name:  "attach",
+				Usage: "SERVICE",
+				Short: "Attach the terminal to the main process of a running service",
+				Flags: func(flags *flag.FlagSet, ctx *Context) {
+					flags.BoolVar(&ctx.NoStdin, "no-stdin", false, "do not attach stdin")
+				},
+				Run: attachToService,
+			},
+			{
+				Name:  "events",
+				Short: "Follow the lifecycle events of all services",
+				Run: noArgs(func(ctx *Context) error {
+					if ctx.Standalone {
+						return fmt.Errorf("events requires a gompose server")
+					}
+					return FollowEvents(ctx)
+				}),
+			},
+			{
+				Name:  "config",
+				Short: "Validate and print the definition",
+				Flags: func(flags *flag.FlagSet, ctx *Context) {
+					flags.StringVar(&ctx.Format, "format", "yaml", "output format: yaml or json")
+					showSecretsFlag(flags, ctx)
+				},
+				Run: noArgs(printConfig),
+			},
+			{
+				Name:  "completion",
+				Usage: "bash|zsh",
+				Short: "Generate a shell completion script",
+				Run:   completion,
+			},
+		},
+	}
+	// gompose cli COMMAND is how commands were run before the command tree,
+	// and is kept for existing scripts
+	alias := &Command{
+		Name:   "cli",
+		Short:  "Run a command on the gompose server",
+		Hidden: true,
+	}
+	for _, sub := range cmd.Commands {
+		if sub.Name != "server" && sub.Name != "completion" {
+			alias.Commands = append(alias.Commands, sub)
+		}
+	}
+	cmd.Commands = append(cmd.Commands, alias)
+	return cmd
+}
+
+func timeoutFlag(flags *flag.FlagSet, ctx *Context) {
+	flags.Var(&ctx.Timeout, "timeout", "seconds, or a duration such as 1m30s, to wait for services to stop before killing them, 0 to kill them immediately (default: their stop_grace_period)")
+}
+
+func tokenFileFlag(flags *flag.FlagSet, ctx *Context) {
+	flags.StringVar(&ctx.TokenFile, "tokens", stringOr(ctx.TokenFile, server.DefaultTokenFile), "file that the bearer tokens are kept in")
+}
+
+func attachFlags(flags *flag.FlagSet, ctx *Context) {
+	flags.BoolVar(&ctx.NoTTY, "T", false, "do not allocate a terminal")
+	flags.Var(&ctx.Env, "e", "set an environment variable as KEY=VALUE, may be repeated")
+	flags.StringVar(&ctx.User, "user", "", "run the command as this user")
+	flags.StringVar(&ctx.WorkDir, "workdir", "", "working directory of the command")
+}
+
+func showSecretsFlag(flags *flag.FlagSet, ctx *Context) {
+	flags.BoolVar(&ctx.ShowSecrets, "show-secrets", false, "do not redact secrets and sensitive environment values")
+}
+
+func formatFlag(flags *flag.FlagSet, ctx *Context) {
+	flags.StringVar(&ctx.Format, "format", "table", "output format: table or json")
+}
+
+func noArgs(run func(ctx *Context) error) func(ctx *Context, args []string) error {
+	return func(ctx *Context, args []string) error {
+		if len(args) != 0 {
+			return usageErrorf("unexpected arguments: %v", args)
+		}
+		return run(ctx)
+	}
+}
+
+// dispatch runs the command on the server, or in this process when running
+// standalone.
+func dispatch(cmd string) func(ctx *Context) error {
+	return func(ctx *Context) error {
+		if ctx.Standalone {
+			return runLocal(ctx, cmd)
+		}
+		return RunCommand(ctx, cmd)
+	}
+}
+
+func startServer(ctx *Context) error {
+	if ctx.Detach {
+		return detach(ctx)
+	}
+	if ctx.LogFile != "" {
+		file, err := server.OpenRotatingFile(ctx.LogFile, int64(ctx.LogMaxSize)<<20, ctx.LogBackups)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		log.SetOutput(file)
+		if ctx.LogStderr {
+			if err := file.CaptureStderr(); err != nil {
+				return err
+			}
+		}
+	}
+	srv, err := server.New(server.Options{
+		StateStore: ctx.Store,
+		Address:    ctx.Listen,
+		Socket:     ctx.Socket,
+		TLS: server.TLSOptions{
+			Cert:     ctx.TLSCert,
+			Key:      ctx.TLSKey,
+			ClientCA: ctx.TLSCA,
+		},
+		Auth:        ctx.Auth,
+		Insecure:    ctx.Insecure,
+		TokenFile:   ctx.TokenFile,
+		DisableExec: ctx.DisableExec,
+		StopOnExit:  ctx.StopServices,
+		PidFile:     ctx.PidFile,
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("starting gompose server %s\n", server.Version)
+	return srv.Start()
+}
+
+func serverStatus(ctx *Context) error {
+	status, err := GetStatus(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("version: %s, pid: %d, uptime: %s\n", status.Version, status.Pid, status.Uptime)
+	for _, project := range status.Projects {
+		operation := project.Operation
+		if operation == "" {
+			operation = "idle"
+		}
+		fmt.Printf("%-20s containers: %d, processes: %d, operation: %s (%d pending)\n",
+			project.Name, project.Containers, project.Processes, operation, project.Pending)
+	}
+	return nil
+}
+
+func serviceLogs(ctx *Context, args []string) error {
+	if len(args) != 1 {
+		return usageErrorf("logs requires exactly one service")
+	}
+	if ctx.Standalone {
+		return logsLocal(ctx, args[0])
+	}
+	return StreamLogs(ctx, args[0])
+}
+
+func loadDefinition(ctx *Context) ([]byte, compose.Definition, error) {
+	data, err := ioutil.ReadFile(ctx.File)
+	if err != nil {
+		return nil, compose.Definition{}, err
+	}
+	var definition compose.Definition
+	if err := yaml.Unmarshal(data, &definition); err != nil {
+		return nil, compose.Definition{}, fmt.Errorf("invalid definition %s: %v", ctx.File, err)
+	}
+	return data, definition, nil
+}
+
+// loadInlineDefinition loads the definition with the content of the files and
+// environment variables of its secrets and configs given inline, as they are
+// read by the client rather than by the server. Relative files are read from
+// the directory of the definition.
+func loadInlineDefinition(ctx *Context) ([]byte, compose.Definition, error) {
+	data, definition, err := loadDefinition(ctx)
+	if err != nil {
+		return nil, compose.Definition{}, err
+	}
+	if len(definition.Secrets)+len(definition.Configs) == 0 {
+		return data, definition, nil
+	}
+	if definition, err = definition.Inline(filepath.Dir(ctx.File)); err != nil {
+		return nil, compose.Definition{}, err
+	}
+	// only the secrets and configs are replaced, the rest of the definition is
+	// sent as written
+	var document yaml.MapSlice
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, compose.Definition{}, err
+	}
+	for i, item := range document {
+		switch item.Key {
+		case "secrets":
+			document[i].Value = definition.Secrets
+		case "configs":
+			document[i].Value = definition.Configs
+		}
+	}
+	if data, err = yaml.Marshal(document); err != nil {
+		return nil, compose.Definition{}, err
+	}
+	return data, definition, nil
+}
+
+func printConfig(ctx *Context) error {
+	_, definition, err := loadDefinition(ctx)
+	if err != nil {
+		return err
+	}
+	if err := definition.Validate(); err != nil {
+		return err
+	}
+	definition = definition.WithProfiles(ctx.profiles())
+	if !ctx.ShowSecrets {
+		definition = definition.Redacted()
+	}
+	switch ctx.Format {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(definition)
+	case "yaml":
+		data, err := yaml.Marshal(definition)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	default:
+		return usageErrorf("unknown format: %s", ctx.Format)
+	}
+}
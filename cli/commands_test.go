@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Pungyeon/docker-gompose/compose"
+	"gopkg.in/yaml.v2"
+)
+
+func TestLoadInlineDefinition(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gompose-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "config.yaml")
+	definition := "services:\n  db:\n    image: postgres\n    secrets: [password]\nsecrets:\n  password:\n    file: password.txt\n"
+	if err := ioutil.WriteFile(file, []byte(definition), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "password.txt"), []byte("hunter2"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _, err := loadInlineDefinition(&Context{File: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "password.txt") {
+		t.Errorf("the file of the secret is sent to the server:\n%s", data)
+	}
+	var sent compose.Definition
+	if err := yaml.Unmarshal(data, &sent); err != nil {
+		t.Fatal(err)
+	}
+	if got := sent.Secrets["password"]; got != (compose.FileSource{Content: "hunter2"}) {
+		t.Errorf("secret = %+v, expected its content inline", got)
+	}
+	if got := sent.Services["db"].Image; got != "postgres" {
+		t.Errorf("image = %q, expected the rest of the definition unchanged", got)
+	}
+}
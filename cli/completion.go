@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+func completion(ctx *Context, args []string) error {
+	if len(args) != 1 {
+		return usageErrorf("completion requires a shell: bash or zsh")
+	}
+	switch args[0] {
+	case "bash":
+		return writeBashCompletion(os.Stdout, root())
+	case "zsh":
+		fmt.Fprintln(os.Stdout, "autoload -U +X bashcompinit && bashcompinit")
+		return writeBashCompletion(os.Stdout, root())
+	default:
+		return usageErrorf("unsupported shell: %s", args[0])
+	}
+}
+
+// completions maps the path of every command to the words that may follow
+// it: its sub commands and flags.
+func completions(cmd *Command, path string, words map[string][]string) {
+	flags := flag.NewFlagSet(cmd.Name, flag.ContinueOnError)
+	globalFlags(flags, &Context{})
+	if cmd.Flags != nil {
+		cmd.Flags(flags, &Context{})
+	}
+	var options []string
+	for _, sub := range cmd.Commands {
+		if sub.Hidden {
+			continue
+		}
+		options = append(options, sub.Name)
+		completions(sub, path+" "+sub.Name, words)
+	}
+	flags.VisitAll(func(f *flag.Flag) {
+		options = append(options, "-"+f.Name)
+	})
+	words[path] = options
+}
+
+func writeBashCompletion(w io.Writer, cmd *Command) error {
+	words := map[string][]string{}
+	completions(cmd, cmd.Name, words)
+	var paths []string
+	for path := range words {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	fmt.Fprintf(w, "_%s() {\n", cmd.Name)
+	fmt.Fprintln(w, `	local cur path word
+	cur="${COMP_WORDS[COMP_CWORD]}"
+	path="${COMP_WORDS[0]##*/}"
+	for word in "${COMP_WORDS[@]:1:COMP_CWORD-1}"; do
+		case "$word" in
+			-*) ;;
+			*) path="$path $word" ;;
+		esac
+	done
+	case "$path" in`)
+	for _, path := range paths {
+		fmt.Fprintf(w, "\t\t%q) COMPREPLY=($(compgen -W %q -- \"$cur\")) ;;\n", path, strings.Join(words[path], " "))
+	}
+	fmt.Fprintln(w, "\tesac\n}")
+	_, err := fmt.Fprintf(w, "complete -F _%s %s\n", cmd.Name, cmd.Name)
+	return err
+}
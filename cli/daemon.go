@@ -0,0 +1,152 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Pungyeon/docker-gompose/server"
+)
+
+const (
+	defaultPidFile = ".gompose/server.pid"
+	defaultLogFile = ".gompose/server.log"
+)
+
+// detach starts the server again as a background process, without the
+// --detach flag, and waits until it is listening.
+func detach(ctx *Context) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	var args []string
+	for _, arg := range os.Args[1:] {
+		switch strings.TrimLeft(arg, "-") {
+		case "detach", "detach=true", "detach=1":
+			continue
+		}
+		args = append(args, arg)
+	}
+	if ctx.LogFile == "" {
+		ctx.LogFile = defaultLogFile
+		args = append(args, "-log-file", ctx.LogFile)
+	}
+	if ctx.PidFile == "" {
+		ctx.PidFile = defaultPidFile
+		args = append(args, "-pidfile", ctx.PidFile)
+	}
+	if !ctx.LogStderr {
+		args = append(args, "-log-stderr")
+	}
+	if pid, ok := server.ReadPidFile(ctx.PidFile); ok {
+		return fmt.Errorf("a gompose server is already running with pid %d", pid)
+	}
+
+	devNull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer devNull.Close()
+	// errors occurring before the server opens its log file are appended to
+	// it as well
+	if err := os.MkdirAll(filepath.Dir(ctx.LogFile), 0700); err != nil {
+		return err
+	}
+	logFile, err := os.OpenFile(ctx.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+	cmd := exec.Command(executable, args...)
+	cmd.Stdin = devNull
+	cmd.Stdout = devNull
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = detachedProcAttr()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	deadline := time.After(time.Second * 30)
+	for {
+		select {
+		case err := <-exited:
+			return fmt.Errorf("the gompose server exited (%v), see %s", err, ctx.LogFile)
+		case <-deadline:
+			return fmt.Errorf("timed out waiting for the gompose server to start, see %s", ctx.LogFile)
+		case <-time.After(time.Millisecond * 100):
+		}
+		// the pid file is written once the server is listening
+		if pid, ok := server.ReadPidFile(ctx.PidFile); ok && pid == cmd.Process.Pid {
+			fmt.Printf("gompose server started with pid %d, logging to %s\n", pid, ctx.LogFile)
+			return nil
+		}
+	}
+}
+
+func systemdUnit(ctx *Context, args []string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	command := []string{executable, "server", "start"}
+	return writeSystemdUnit(os.Stdout, dir, append(command, args...), ctx.UserUnit)
+}
+
+// writeSystemdUnit writes a unit running the server in the foreground. Only
+// the server is killed when the unit is stopped, so that the services are
+// adopted when it is started again.
+func writeSystemdUnit(w io.Writer, dir string, command []string, userUnit bool) error {
+	// systemd expands specifiers (%) and environment variables ($) even in
+	// quoted arguments, so both are escaped by doubling them
+	escape := strings.NewReplacer("%", "%%", "$", "$$")
+	quoted := make([]string, len(command))
+	for i, arg := range command {
+		quoted[i] = arg
+		if arg == "" || strings.ContainsAny(arg, " \t\"'\\$%;") {
+			quoted[i] = strconv.Quote(escape.Replace(arg))
+		}
+	}
+	fmt.Fprintln(w, "[Unit]")
+	fmt.Fprintln(w, "Description=gompose server")
+	fmt.Fprintln(w, "After=network-online.target docker.service")
+	fmt.Fprintln(w, "Wants=network-online.target")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "[Service]")
+	fmt.Fprintln(w, "Type=simple")
+	if !userUnit {
+		current, err := user.Current()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "User=%s\n", current.Username)
+	}
+	fmt.Fprintf(w, "WorkingDirectory=%s\n", dir)
+	fmt.Fprintf(w, "ExecStart=%s\n", strings.Join(quoted, " "))
+	fmt.Fprintln(w, "KillMode=process")
+	fmt.Fprintln(w, "TimeoutStopSec=45")
+	fmt.Fprintln(w, "Restart=on-failure")
+	fmt.Fprintln(w, "RestartSec=5")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "[Install]")
+	if userUnit {
+		_, err := fmt.Fprintln(w, "WantedBy=default.target")
+		return err
+	}
+	_, err := fmt.Fprintln(w, "WantedBy=multi-user.target")
+	return err
+}
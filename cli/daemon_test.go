@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteSystemdUnitQuoting(t *testing.T) {
+	tests := []struct {
+		name    string
+		command []string
+		want    string
+	}{
+		{
+			name:    "plain",
+			command: []string{"/usr/bin/gompose", "server", "start"},
+			want:    "ExecStart=/usr/bin/gompose server start",
+		},
+		{
+			name:    "spaces",
+			command: []string{"/opt/my tools/gompose", "server", "start"},
+			want:    `ExecStart="/opt/my tools/gompose" server start`,
+		},
+		{
+			name:    "empty",
+			command: []string{"/usr/bin/gompose", "server", "start", "-file", ""},
+			want:    `ExecStart=/usr/bin/gompose server start -file ""`,
+		},
+		{
+			name:    "quotes",
+			command: []string{"/usr/bin/gompose", "server", "start", `-file=it's "here"`},
+			want:    `ExecStart=/usr/bin/gompose server start "-file=it's \"here\""`,
+		},
+		{
+			name:    "specifiers",
+			command: []string{"/usr/bin/gompose", "server", "start", "-file=%h/compose.yml"},
+			want:    `ExecStart=/usr/bin/gompose server start "-file=%%h/compose.yml"`,
+		},
+		{
+			name:    "environment variables",
+			command: []string{"/usr/bin/gompose", "server", "start", "-file=$HOME/compose.yml"},
+			want:    `ExecStart=/usr/bin/gompose server start "-file=$$HOME/compose.yml"`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buffer bytes.Buffer
+			if err := writeSystemdUnit(&buffer, "/srv/app", test.command, true); err != nil {
+				t.Fatal(err)
+			}
+			var execStart string
+			for _, line := range strings.Split(buffer.String(), "\n") {
+				if strings.HasPrefix(line, "ExecStart=") {
+					execStart = line
+				}
+			}
+			if execStart != test.want {
+				t.Errorf("unit contains %q, expected %q", execStart, test.want)
+			}
+		})
+	}
+}
+
+func TestWriteSystemdUnitUser(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := writeSystemdUnit(&buffer, "/srv/app", []string{"gompose"}, true); err != nil {
+		t.Fatal(err)
+	}
+	unit := buffer.String()
+	if strings.Contains(unit, "User=") {
+		t.Errorf("user unit sets the user:\n%s", unit)
+	}
+	for _, want := range []string{"WorkingDirectory=/srv/app\n", "KillMode=process\n", "WantedBy=default.target\n"} {
+		if !strings.Contains(unit, want) {
+			t.Errorf("unit does not contain %q:\n%s", want, unit)
+		}
+	}
+}
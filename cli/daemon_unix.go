@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package cli
+
+import "syscall"
+
+// detachedProcAttr starts the process in its own session, so that it is not
+// terminated along with the terminal it was started from.
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
@@ -0,0 +1,7 @@
+package cli
+
+import "syscall"
+
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{}
+}
@@ -0,0 +1,132 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/Pungyeon/docker-gompose/compose"
+)
+
+const barWidth = 30
+
+type serviceProgress struct {
+	status string
+	layers map[string]compose.PullProgress
+}
+
+// Renderer displays the progress of a command. On a terminal every service
+// is shown on its own line, which is redrawn in place along with a progress
+// bar while its image is being pulled. Otherwise events are printed as plain
+// lines as they arrive.
+type Renderer struct {
+	out      io.Writer
+	tty      bool
+	order    []string
+	services map[string]*serviceProgress
+	drawn    int
+}
+
+func NewRenderer(out io.Writer, tty bool) *Renderer {
+	return &Renderer{
+		out:      out,
+		tty:      tty,
+		services: map[string]*serviceProgress{},
+	}
+}
+
+func (renderer *Renderer) Event(event compose.LifecycleEvent) {
+	if !renderer.tty {
+		renderer.plain(event)
+		return
+	}
+	if event.Service == "" {
+		renderer.above(fmt.Sprintf("%s %s %s", event.Type, event.Message, event.ID))
+		return
+	}
+	progress := renderer.service(event.Service)
+	if event.Type == compose.ImagePull && event.Progress != nil {
+		if event.Progress.Layer != "" {
+			progress.layers[event.Progress.Layer] = *event.Progress
+		}
+		progress.status = "pulling"
+	} else {
+		progress.status = strings.TrimPrefix(string(event.Type), "service.")
+		if event.Message != "" && event.Type == compose.ServiceFailed {
+			progress.status += ": " + event.Message
+		}
+	}
+	renderer.draw()
+}
+
+// Output prints command output above the progress lines.
+func (renderer *Renderer) Output(output string) {
+	if !renderer.tty {
+		fmt.Fprint(renderer.out, output)
+		return
+	}
+	renderer.above(strings.TrimRight(output, "\n"))
+}
+
+func (renderer *Renderer) plain(event compose.LifecycleEvent) {
+	if event.Type == compose.ImagePull && event.Progress != nil && event.Progress.Total > 0 {
+		// skip the intermediate download and extraction updates
+		return
+	}
+	fmt.Fprintf(renderer.out, "%-18s %-15s %s %s\n", event.Type, event.Service, event.ID, event.Message)
+}
+
+func (renderer *Renderer) service(name string) *serviceProgress {
+	progress, ok := renderer.services[name]
+	if !ok {
+		progress = &serviceProgress{layers: map[string]compose.PullProgress{}}
+		renderer.services[name] = progress
+		renderer.order = append(renderer.order, name)
+		sort.Strings(renderer.order)
+	}
+	return progress
+}
+
+func (renderer *Renderer) clear() {
+	for i := 0; i < renderer.drawn; i++ {
+		fmt.Fprint(renderer.out, "\033[1A\033[2K")
+	}
+	renderer.drawn = 0
+}
+
+func (renderer *Renderer) above(line string) {
+	renderer.clear()
+	fmt.Fprintln(renderer.out, line)
+	renderer.draw()
+}
+
+func (renderer *Renderer) draw() {
+	renderer.clear()
+	for _, name := range renderer.order {
+		progress := renderer.services[name]
+		line := fmt.Sprintf("%-20s %s", name, progress.status)
+		if progress.status == "pulling" {
+			line += " " + progressBar(progress.layers)
+		}
+		fmt.Fprintln(renderer.out, line)
+		renderer.drawn++
+	}
+}
+
+func progressBar(layers map[string]compose.PullProgress) string {
+	var current, total int64
+	for _, layer := range layers {
+		current += layer.Current
+		total += layer.Total
+	}
+	if total == 0 {
+		return ""
+	}
+	filled := int(current * barWidth / total)
+	if filled > barWidth {
+		filled = barWidth
+	}
+	return fmt.Sprintf("[%s%s] %3d%%", strings.Repeat("=", filled), strings.Repeat(" ", barWidth-filled),
+		current*100/total)
+}
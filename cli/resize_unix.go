@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package cli
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func notifyResize(signals chan<- os.Signal) {
+	signal.Notify(signals, syscall.SIGWINCH)
+}
@@ -0,0 +1,7 @@
+package cli
+
+import "os"
+
+// the size of the terminal is only sent once on windows, which has no signal
+// for it being resized.
+func notifyResize(signals chan<- os.Signal) {}
@@ -0,0 +1,112 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/Pungyeon/docker-gompose/compose"
+	"github.com/Pungyeon/docker-gompose/server"
+	"github.com/Pungyeon/docker-gompose/utils"
+)
+
+// openLocal opens the project in this process. The state is locked while it
+// is open, so a command fails rather than racing a server managing the same
+// project: standalone mode can't share the state with a running server.
+func openLocal(ctx *Context) (*compose.App, error) {
+	project := stringOr(ctx.Project, compose.DefaultProject)
+	app, err := server.OpenApp(project, server.Options{
+		StateStore: ctx.Store,
+	})
+	var locked compose.LockedError
+	if errors.As(err, &locked) {
+		return nil, fmt.Errorf("project %s is managed by a running gompose server, which holds the lock on %s: "+
+			"run the command without --standalone to send it to the server, or stop the server with gompose server stop", project, locked.Path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v (run without --standalone to use the server)", err)
+	}
+	return app, nil
+}
+
+// messageWriter sends everything written to it as command output.
+type messageWriter chan<- server.StreamMessage
+
+func (w messageWriter) Write(data []byte) (int, error) {
+	w <- server.StreamMessage{Output: string(data)}
+	return len(data), nil
+}
+
+// runLocal executes the command in this process, rendering its progress the
+// same way as when it is streamed from a server. Services started by the
+// command keep running after it returns, and are adopted by the next command
+// or server.
+func runLocal(ctx *Context, cmd string) (err error) {
+	_, definition, err := loadInlineDefinition(ctx)
+	if err != nil {
+		return err
+	}
+	if err := definition.Validate(); err != nil {
+		return err
+	}
+	options := compose.RunOptions{
+		Format:        ctx.Format,
+		Timeout:       ctx.Timeout.duration(),
+		RemoveVolumes: ctx.RemoveVolumes,
+		RemoveOrphans: ctx.RemoveOrphans,
+		RemoveImages:  ctx.RemoveImages,
+		Profiles:      ctx.profiles(),
+	}
+	if err := options.Validate(); err != nil {
+		return usageErrorf("%v", err)
+	}
+	app, err := openLocal(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = utils.CombineErrors(err, app.Shutdown(false))
+	}()
+
+	messages := make(chan server.StreamMessage, 64)
+	rendered := make(chan struct{})
+	go func() {
+		defer close(rendered)
+		renderer := ctx.renderer()
+		for msg := range messages {
+			ctx.render(renderer, msg)
+		}
+	}()
+	events, unsubscribe := app.Events().Subscribe()
+	forwarded := make(chan struct{})
+	go func() {
+		defer close(forwarded)
+		for event := range events {
+			event := event
+			messages <- server.StreamMessage{Event: &event}
+		}
+	}()
+
+	err = app.RunWithDefinition(cmd, definition, options, messageWriter(messages))
+	unsubscribe()
+	<-forwarded
+	close(messages)
+	<-rendered
+	return err
+}
+
+func logsLocal(ctx *Context, service string) error {
+	app, err := openLocal(ctx)
+	if err != nil {
+		return err
+	}
+	return utils.HandleErrors(utils.ReturnError,
+		app.Logs(context.Background(), service, compose.LogOptions{
+			Follow:      ctx.Follow,
+			Tail:        ctx.Tail,
+			ShowSecrets: ctx.ShowSecrets,
+		}, os.Stdout),
+		app.Shutdown(false),
+	)
+}
@@ -0,0 +1,43 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Pungyeon/docker-gompose/server"
+)
+
+func createToken(ctx *Context, args []string) error {
+	if len(args) != 1 {
+		return usageErrorf("token create requires exactly one name")
+	}
+	token, err := server.NewTokenStore(ctx.TokenFile).Create(args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "store this token safely, it will not be shown again:")
+	fmt.Println(token)
+	return nil
+}
+
+func listTokens(ctx *Context) error {
+	tokens, err := server.NewTokenStore(ctx.TokenFile).List()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tCREATED")
+	for _, token := range tokens {
+		fmt.Fprintf(tw, "%s\t%s\n", token.Name, token.Created.Local().Format(time.RFC3339))
+	}
+	return tw.Flush()
+}
+
+func revokeToken(ctx *Context, args []string) error {
+	if len(args) != 1 {
+		return usageErrorf("token revoke requires exactly one name")
+	}
+	return server.NewTokenStore(ctx.TokenFile).Revoke(args[0])
+}
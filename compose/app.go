@@ -8,8 +8,7 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
-	"os"
-	"os/exec"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -17,6 +16,7 @@ import (
 	"github.com/Pungyeon/docker-gompose/utils"
 	"github.com/corticph/go-logging/pkg/logging"
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
 	"gopkg.in/yaml.v2"
@@ -36,22 +36,63 @@ func NewResult() *Result {
 
 type App struct {
 	cli         *client.Client
-	dependants  chan Dependency
-	serviceDone chan string
 	wg          sync.WaitGroup
+	mu          sync.RWMutex
+	queue       *commandQueue
+	store       StateStore
+	events      *EventBus
+	project     string
+	execEnabled bool
+	// stdins holds the stdin of the EXEC processes with stdin_open, which
+	// are lost when the server exits.
+	stdins map[string]io.WriteCloser
+	// redactor hides the content of the secrets of the started services
+	// from their logs.
+	redactor *Redactor
 
-	Volumes    map[string]string
-	NetworkID  string
-	Containers map[string]Process
-	Processes  map[string]Process
+	Volumes          map[string]string
+	ExternalVolumes  map[string]string
+	NetworkID        string
+	ExternalNetworks map[string]string
+	Containers       map[string]Process
+	Processes        map[string]Process
+	Images           map[string]string
 }
 
-func NewApp() (*App, error) {
+func NewApp(project string, store StateStore) (*App, error) {
 	cli, err := client.NewClientWithOpts(client.FromEnv)
 	if err != nil {
 		return &App{}, err
 	}
-	return loadLockFile(cli)
+	app, err := loadState(cli, store)
+	if err != nil {
+		return nil, err
+	}
+	app.project = project
+	return app, nil
+}
+
+// DisableExec refuses to start services using the EXEC driver, which run
+// arbitrary commands on the host.
+func (app *App) DisableExec() {
+	app.execEnabled = false
+}
+
+func (app *App) Project() string {
+	return app.project
+}
+
+func (app *App) networkName() string {
+	return app.project + "-network"
+}
+
+// containerName prefixes the service name with the project, unless it is the
+// default project, in which case the service name is used as is.
+func (app *App) containerName(service string) string {
+	if app.project == DefaultProject {
+		return service
+	}
+	return app.project + "_" + service
 }
 
 func (app *App) Monitor() {
@@ -73,200 +114,369 @@ func (app *App) Wait() error {
 	return nil
 }
 
-func (app *App) AddDependency(d Dependency) {
-	app.dependants <- d
+// CurrentOperation returns the mutating command currently being executed, if
+// any, and how many commands are queued including it.
+func (app *App) CurrentOperation() (string, int) {
+	return app.queue.Current()
 }
 
-func (app *App) Done(service string) {
-	app.serviceDone <- service
-}
-
-func loadLockFile(cli *client.Client) (*App, error) {
-	app, err := createAppFromLockFile(cli)
+func loadState(cli *client.Client, store StateStore) (*App, error) {
+	app, err := createAppFromStore(store)
 	if err != nil {
 		return nil, err
 	}
-	dep, done := newDepedencyHandlers()
 	app.cli = cli
-	app.dependants = dep
-	app.serviceDone = done
+	app.store = store
+	app.queue = newCommandQueue()
+	app.events = NewEventBus()
+	app.execEnabled = true
+	app.stdins = map[string]io.WriteCloser{}
+	app.redactor = NewRedactor()
+	app.reconcileProcesses()
+	app.restoreRedactor()
 	return app, nil
 }
 
-func createAppFromLockFile(cli *client.Client) (*App, error) {
-	lock, err := ioutil.ReadFile(".gompose.lock")
-	if err != nil {
-		if os.IsNotExist(err) {
-			logging.Info("no lock file found, creating new environment")
-			return &App{
-				Volumes:    map[string]string{},
-				Containers: map[string]Process{},
-				Processes:  map[string]Process{},
-				dependants: make(chan Dependency),
-			}, nil
+// restoreRedactor adds the sensitive values of the running services to the
+// redactor, so that their logs and events stay redacted after a restart. The
+// values are read back from the environment and the secrets of the services,
+// as they are not stored with the state.
+func (app *App) restoreRedactor() {
+	containers, processes := app.snapshot()
+	for name, proc := range containers {
+		app.redactor.Add(readSecretFiles(proc.FilesDir)...)
+		if len(proc.SensitiveEnv) == 0 || app.cli == nil {
+			continue
 		}
-		return nil, err
+		inspect, err := app.cli.ContainerInspect(context.Background(), proc.ID)
+		if err != nil {
+			log.Printf("could not read the environment of %s: %v", name, err)
+			continue
+		}
+		if inspect.Config != nil {
+			app.redactor.Add(envValues(inspect.Config.Env, proc.SensitiveEnv)...)
+		}
+	}
+	for name, proc := range processes {
+		if proc.Status != RUNNING {
+			continue
+		}
+		if proc.FilesDir != "" {
+			app.redactor.Add(readSecretFiles(filepath.Join(proc.FilesDir, "secrets"))...)
+		}
+		if len(proc.SensitiveEnv) == 0 {
+			continue
+		}
+		env, err := processEnviron(proc.PID)
+		if err != nil {
+			log.Printf("could not read the environment of %s: %v", name, err)
+			continue
+		}
+		app.redactor.Add(envValues(env, proc.SensitiveEnv)...)
 	}
-	var app *App
-	if err := json.Unmarshal(lock, &app); err != nil {
+}
+
+func createAppFromStore(store StateStore) (*App, error) {
+	state, err := store.Load()
+	if err != nil {
 		return nil, err
 	}
-	return app, nil
+	return &App{
+		Volumes:          state.Volumes,
+		ExternalVolumes:  state.ExternalVolumes,
+		NetworkID:        state.NetworkID,
+		ExternalNetworks: state.ExternalNetworks,
+		Containers:       state.Containers,
+		Processes:        state.Processes,
+		Images:           state.Images,
+	}, nil
 }
 
-func newDepedencyHandlers() (chan Dependency, chan string) {
-	waiter := make(chan Dependency)
-	doneService := make(chan string)
-	go func() {
-		var dependants []Dependency
-		for {
-			select {
-			case d := <-waiter:
-				dependants = append(dependants, d)
-			case service := <-doneService:
-				for _, d := range dependants {
-					if d.service == service {
-						d.channel <- true
-					}
-				}
-			}
-		}
-	}()
-	return waiter, doneService
+func (app *App) State() State {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+	state := NewState()
+	state.NetworkID = app.NetworkID
+	for name, id := range app.Volumes {
+		state.Volumes[name] = id
+	}
+	for name, volume := range app.ExternalVolumes {
+		state.ExternalVolumes[name] = volume
+	}
+	for name, id := range app.ExternalNetworks {
+		state.ExternalNetworks[name] = id
+	}
+	for name, proc := range app.Containers {
+		state.Containers[name] = proc
+	}
+	for name, proc := range app.Processes {
+		state.Processes[name] = proc
+	}
+	for name, image := range app.Images {
+		state.Images[name] = image
+	}
+	return state
 }
 
 func (app *App) Save() error {
-	data, err := json.Marshal(app)
-	if err != nil {
-		return err
-	}
-	return ioutil.WriteFile(".gompose.lock", data, 0700)
+	operation, _ := app.queue.Current()
+	return app.store.Save(app.State(), operation)
+}
+
+// Close releases the lock on the state, allowing another gompose server to
+// manage the environment.
+func (app *App) Close() error {
+	return app.store.Close()
+}
+
+// Shutdown waits for the queued operations to complete, optionally stops all
+// services, then saves the state and releases the lock. Services that are not
+// stopped keep running, and are adopted when the state is loaded again.
+func (app *App) Shutdown(stop bool) error {
+	return app.queue.run(0, "shutdown", ioutil.Discard, func() error {
+		if stop {
+			if err := app.stop(ioutil.Discard, RunOptions{}); err != nil {
+				log.Println(err)
+			}
+		}
+		return utils.HandleErrors(utils.ReturnError, app.Save(), app.Close())
+	})
 }
 
 func (app *App) Run(cmd string) error {
+	options := RunOptions{}
 	switch cmd {
 	case "start":
 		return app.start(&bytes.Buffer{})
 	case "ps":
-		return app.ps(&bytes.Buffer{})
+		return app.ps(&bytes.Buffer{}, options)
+	case "images":
+		return app.images(&bytes.Buffer{}, options)
+	case "pin":
+		return app.pinImages(&bytes.Buffer{})
 	case "clean", "rm":
-		app.clean(&bytes.Buffer{})
-		return nil
+		return app.clean(&bytes.Buffer{}, Definition{}, options)
 	case "stop":
-		return app.stop()
+		return app.stop(&bytes.Buffer{}, options)
 	default:
-		Help()
-		return nil
+		return fmt.Errorf("unknown command: %s", cmd)
 	}
 }
 
-func (app *App) RunWithDefinition(cmd string, definition Definition, writer io.Writer) error {
+// RunWithDefinition executes the given command. Read-only commands are run
+// immediately, whereas commands that mutate the environment are serialized
+// through the command queue, and the state is saved once they complete.
+func (app *App) RunWithDefinition(cmd string, definition Definition, options RunOptions, writer io.Writer) error {
 	switch cmd {
-	case "start":
-		return app.startWithDefinition(definition, writer)
 	case "ps":
-		return app.ps(writer)
+		return app.ps(writer, options)
+	case "images":
+		return app.images(writer, options)
+	case "history":
+		return app.history(writer)
+	case "start", "restart", "pin", "clean", "rm", "stop":
+	default:
+		return fmt.Errorf("unknown command: %s", cmd)
+	}
+	return app.redactor.Error(app.queue.run(options.Operation, cmd, writer, func() error {
+		return utils.HandleErrors(utils.ReturnError,
+			app.runWithDefinition(cmd, definition, options, writer),
+			app.Wait(),
+			app.Save(),
+		)
+	}))
+}
+
+func (app *App) runWithDefinition(cmd string, definition Definition, options RunOptions, writer io.Writer) error {
+	switch cmd {
+	case "start":
+		return app.startWithDefinition(definition.WithProfiles(options.Profiles), writer)
+	case "restart":
+		if err := app.stop(writer, options); err != nil {
+			return err
+		}
+		return app.startWithDefinition(definition.WithProfiles(options.Profiles), writer)
+	case "pin":
+		return app.pinImages(writer)
 	case "clean", "rm":
 		buffer := &bytes.Buffer{}
-		app.clean(buffer)
+		err := app.clean(buffer, definition, options)
 		writer.Write(buffer.Bytes())
-		return nil
+		return err
 	case "stop":
-		return app.stop()
+		return app.stop(writer, options)
 	default:
-		Help()
-		return nil
+		return fmt.Errorf("unknown command: %s", cmd)
 	}
 }
 
-func Help() {
-	fmt.Println(`Docker Gompose v0.1 
-	To interact with the Gompose server, please use the 'server' command and one of the following:
-		start - (start an instance of the Gompose server)
-		stop  - (stop the currently running instance of the Gompose server)
-
-	Full example:
-		#> gompose server start
-
-	To use the cli interface for invoking commands on the server, please specify one of the following:
-		start - (start the Containers and executables specified in config)
-		ps  - (list all running Containers and executables specified in config)
-		rm  - (clean all networks, Volumes and Containers)
-		stop - (stop all running containers)
-
-	Full example:
-		#> gompose cli ps
-`)
+// stop stops every service, writing which services stopped cleanly and which
+// had to be killed. An error is returned when any service failed to stop.
+func (app *App) stop(writer io.Writer, options RunOptions) error {
+	summary := app.stopAll(options)
+	summary.write(writer)
+	return summary.Err()
 }
 
-func (app *App) stop() error {
-	for name, proc := range app.Containers {
-		if err := app.stopContainer(name, proc); err != nil {
-			log.Println(err)
-		}
-	}
-	app.stopProcesses()
+func (app *App) stopContainerInStore(name string, proc Process, msg string) error {
+	proc.Status = STOPPED
+	app.setContainer(name, proc)
+	app.publish(ServiceExited, name, proc.ID, msg)
 	return nil
 }
 
-func (app *App) stopContainer(name string, proc Process) error {
-	duration := time.Second * 15
-	if proc.StopSignal == "" {
-		if err := app.cli.ContainerStop(context.Background(), proc.ID, &duration); err != nil {
-			return err
-		}
-		return app.stopContainerInStore(name, proc)
+func (app *App) snapshot() (map[string]Process, map[string]Process) {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+	containers := make(map[string]Process, len(app.Containers))
+	for name, proc := range app.Containers {
+		containers[name] = proc
 	}
-	if err := app.cli.ContainerKill(context.Background(), proc.ID, proc.StopSignal); err != nil {
-		log.Println(err)
-		if err := app.cli.ContainerKill(context.Background(), proc.ID, "SIGKILL"); err != nil {
-			return err
-		}
+	processes := make(map[string]Process, len(app.Processes))
+	for name, proc := range app.Processes {
+		processes[name] = proc
 	}
-	return app.stopContainerInStore(name, proc)
+	return containers, processes
 }
 
-func (app *App) stopContainerInStore(name string, proc Process) error {
-	proc.Status = STOPPED
+func (app *App) container(name string) (Process, bool) {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+	proc, ok := app.Containers[name]
+	return proc, ok
+}
+
+func (app *App) process(name string) (Process, bool) {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+	proc, ok := app.Processes[name]
+	return proc, ok
+}
+
+func (app *App) setContainer(name string, proc Process) {
+	app.mu.Lock()
 	app.Containers[name] = proc
-	return nil
+	app.mu.Unlock()
 }
 
-func (app *App) stopProcesses() {
-	for name, proc := range app.Processes {
-		fmt.Printf("\rStopping %s (PID: %s) [PENDING]", name, proc.ID)
-		_, err := os.FindProcess(proc.PID)
-		if err != nil {
-			defer log.Println("NOTE: some errors were encountered:", err)
-		} else {
-			fmt.Printf("\rStopping %s (PID: %s) [STOPPED]\n", name, proc.ID)
-			delete(app.Processes, name)
+func (app *App) setProcess(name string, proc Process) {
+	app.mu.Lock()
+	app.Processes[name] = proc
+	app.mu.Unlock()
+}
+
+// setProcessStopped marks the process as stopped, unless it has already been
+// replaced by a process started since.
+func (app *App) setProcessStopped(name string, pid int) {
+	app.mu.Lock()
+	if proc, ok := app.Processes[name]; ok && proc.PID == pid {
+		proc.Status = STOPPED
+		app.Processes[name] = proc
+	}
+	app.mu.Unlock()
+}
+
+func (app *App) deleteContainer(name string) {
+	app.mu.Lock()
+	delete(app.Containers, name)
+	app.mu.Unlock()
+}
+
+func (app *App) deleteProcess(name string) {
+	app.mu.Lock()
+	delete(app.Processes, name)
+	app.mu.Unlock()
+}
+
+func (app *App) setStdin(name string, stdin io.WriteCloser) {
+	app.mu.Lock()
+	app.stdins[name] = stdin
+	app.mu.Unlock()
+}
+
+func (app *App) stdin(name string) (io.WriteCloser, bool) {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+	stdin, ok := app.stdins[name]
+	return stdin, ok
+}
+
+// deleteStdin removes the stdin of the process, unless it has already been
+// replaced by a process started since.
+func (app *App) deleteStdin(name string, stdin io.WriteCloser) {
+	app.mu.Lock()
+	if app.stdins[name] == stdin {
+		delete(app.stdins, name)
+	}
+	app.mu.Unlock()
+}
+
+// reconcileProcesses verifies that the processes found in the lock file are
+// still the processes that were started by gompose. Processes that are still
+// running are adopted, whereas processes whose leader has exited are marked
+// as stopped. Their process group is never signalled, as their pid may since
+// have been reused by an unrelated process.
+func (app *App) reconcileProcesses() {
+	_, processes := app.snapshot()
+	for name, proc := range processes {
+		if proc.Status != RUNNING {
+			continue
 		}
-		if proc.OnStop != "" {
-			stop := strings.Split(proc.OnStop, " ")
-			if err := exec.Command(stop[0], stop[1:]...).Start(); err != nil {
-				log.Println(err)
-			} else {
-				fmt.Printf("\rStopping %s (PID: %s) [STOPPED]\n", name, proc.ID)
-				delete(app.Processes, name)
-			}
+		same, err := isSameProcess(proc)
+		if err == errIdentityUnsupported {
+			continue
+		}
+		if same {
+			logging.Info(fmt.Sprintf("adopted running process %s (PID: %d)", name, proc.PID))
+			continue
 		}
+		logging.Info(fmt.Sprintf("process %s (PID: %d) is no longer running", name, proc.PID))
+		proc.Status = STOPPED
+		app.setProcess(name, proc)
 	}
 }
 
-func (app *App) clean(writer io.Writer) {
-	app.stopProcesses()
-	for name, proc := range app.Containers {
-		if err := app.stopContainer(name, proc); err != nil {
-			log.Println(err)
+// isSameProcess reports whether the leader of the process is still the
+// process that was started, by comparing its start time. Its command line is
+// not compared, as processes such as postgres rewrite it.
+func isSameProcess(proc Process) (bool, error) {
+	startTime, _, err := processIdentity(proc.PID)
+	if err == errIdentityUnsupported {
+		return false, err
+	}
+	return err == nil && startTime == proc.StartTime, nil
+}
+
+// clean stops and removes the containers and the network of the project.
+// Volumes are only removed when requested, and external volumes are never
+// removed. The rest is still cleaned up when services fail to stop, in which
+// case an error is returned.
+func (app *App) clean(writer io.Writer, definition Definition, options RunOptions) error {
+	summary := app.stopAll(options)
+	summary.write(writer)
+	stopErr := summary.Err()
+	containers, _ := app.snapshot()
+	images := map[string]bool{}
+	for _, proc := range containers {
+		if proc.Image != "" {
+			images[proc.Image] = true
 		}
+	}
+	if options.RemoveOrphans {
+		for _, image := range app.removeOrphans(writer, definition, options) {
+			images[image] = true
+		}
+	}
+	for name, proc := range containers {
 		fmt.Printf("\rRemoving %s [STOPPED]", name)
 		if err := app.cli.ContainerRemove(context.Background(), proc.ID, types.ContainerRemoveOptions{}); err != nil {
 			log.Println(err)
 		}
 		fmt.Printf("\rRemoving %s [REMOVED]\n", name)
+		app.publish(ServiceRemoved, name, proc.ID, "")
 		writer.Write([]byte(fmt.Sprintf("Removed %s [%v][%v]\n", name, proc.Driver, proc.ID)))
-		delete(app.Containers, name)
+		removeServiceFiles(proc.FilesDir)
+		app.deleteContainer(name)
 	}
 
 	if app.NetworkID != "" {
@@ -275,34 +485,181 @@ func (app *App) clean(writer io.Writer) {
 			log.Println(err)
 		}
 		fmt.Printf("\rRemoving Network: %s [REMOVED]\n", app.NetworkID)
+		app.publish(NetworkRemoved, "", app.NetworkID, app.networkName())
 		writer.Write([]byte(fmt.Sprintf("Removing Network: %s [REMOVED]\n", app.NetworkID)))
+		app.mu.Lock()
 		app.NetworkID = ""
+		app.mu.Unlock()
 	}
 
+	if options.RemoveImages != "" {
+		app.removeImages(writer, images, options.RemoveImages == "all")
+	}
+
+	// external networks and volumes are left as they are, and only forgotten
+	app.mu.Lock()
+	app.ExternalNetworks = map[string]string{}
+	if options.RemoveVolumes {
+		app.ExternalVolumes = map[string]string{}
+	}
+	volumes := make(map[string]string, len(app.Volumes))
 	for name, id := range app.Volumes {
+		volumes[name] = id
+	}
+	app.mu.Unlock()
+
+	if !options.RemoveVolumes {
+		if len(volumes) != 0 {
+			writer.Write([]byte(fmt.Sprintf("Keeping %d volume(s), use --volumes to remove them\n", len(volumes))))
+		}
+		return stopErr
+	}
+	for name, id := range volumes {
+		if definition.Volumes[name].External {
+			writer.Write([]byte(fmt.Sprintf("Keeping external volume: %s\n", name)))
+			continue
+		}
 		fmt.Printf("\rRemoving Volume: %s [PENDING]", id)
 		if err := app.cli.VolumeRemove(context.Background(), id, false); err != nil {
 			log.Println(err)
 		}
 		fmt.Printf("\rRemoving Volume: %s [REMOVED]\n", id)
+		app.publish(VolumeRemoved, "", id, name)
 		writer.Write([]byte(fmt.Sprintf("Removing Volume: %s [REMOVED]\n", name)))
+		app.mu.Lock()
 		delete(app.Volumes, name)
+		app.mu.Unlock()
 	}
+	return stopErr
 }
 
-func (app *App) ps(writer io.Writer) error {
+func (app *App) ps(writer io.Writer, options RunOptions) error {
+	containers, processes := app.snapshot()
+	if options.Format == "json" {
+		return writeProcessJSON(writer, containers, processes)
+	}
 	fmt.Printf("\n")
 	buffer := &bytes.Buffer{}
 	buffer.WriteString(fmt.Sprintf("\r%15s | %15s | %10s | %10s\n", "Id", "Name", "Driver", "Status"))
 	buffer.WriteString("-------------------------------------------------------------------------\n")
-	writeProcessPS(buffer, app.Containers)
-	writeProcessPS(buffer, app.Processes)
+	writeProcessPS(buffer, containers)
+	writeProcessPS(buffer, processes)
+	fmt.Println(buffer.String())
+
+	writer.Write(buffer.Bytes())
+	return nil
+}
+
+func (app *App) images(writer io.Writer, options RunOptions) error {
+	buffer := &bytes.Buffer{}
+	buffer.WriteString(fmt.Sprintf("\r%15s | %40s | %71s\n", "Name", "Image", "Digest"))
+	buffer.WriteString("---------------------------------------------------------------------------------------------------------------------------------\n")
+	containers, _ := app.snapshot()
+	var entries []imageEntry
+	for name, proc := range containers {
+		ref, digest, err := app.resolveImage(proc)
+		if err != nil {
+			log.Println(err)
+		}
+		entries = append(entries, imageEntry{Name: name, Image: ref.String(), Digest: digest})
+		buffer.WriteString(fmt.Sprintf("%15s | %40s | %71s\n", name, shortened(ref.String()), digest))
+	}
+	if options.Format == "json" {
+		return json.NewEncoder(writer).Encode(entries)
+	}
+	fmt.Println(buffer.String())
+
+	writer.Write(buffer.Bytes())
+	return nil
+}
+
+func (app *App) history(writer io.Writer) error {
+	store, ok := app.store.(HistoryStore)
+	if !ok {
+		return fmt.Errorf("the configured state store does not keep a history")
+	}
+	entries, err := store.History(20)
+	if err != nil {
+		return err
+	}
+	buffer := &bytes.Buffer{}
+	buffer.WriteString(fmt.Sprintf("\r%25s | %10s | %10s | %10s\n", "Time", "Operation", "Containers", "Processes"))
+	buffer.WriteString("-------------------------------------------------------------------------\n")
+	for _, entry := range entries {
+		buffer.WriteString(fmt.Sprintf("%25s | %10s | %10d | %10d\n", entry.Time.Format(time.RFC3339),
+			entry.Operation, len(entry.State.Containers), len(entry.State.Processes)))
+	}
 	fmt.Println(buffer.String())
 
 	writer.Write(buffer.Bytes())
 	return nil
 }
 
+func (app *App) pinImages(writer io.Writer) error {
+	containers, _ := app.snapshot()
+	for name, proc := range containers {
+		ref, digest, err := app.resolveImage(proc)
+		if err != nil {
+			return err
+		}
+		if digest == "" {
+			return fmt.Errorf("could not resolve digest for image %s of service %s", ref, name)
+		}
+		pinned := ref.WithDigest(digest).String()
+		app.mu.Lock()
+		app.Images[name] = pinned
+		app.mu.Unlock()
+		writer.Write([]byte(fmt.Sprintf("Pinned %s [%s]\n", name, pinned)))
+	}
+	return nil
+}
+
+func (app *App) resolveImage(proc Process) (ImageReference, string, error) {
+	ref, err := ParseImageReference(proc.Image)
+	if err != nil {
+		return ImageReference{}, "", err
+	}
+	ref = ref.Normalized()
+	if ref.Digest != "" {
+		return ref, ref.Digest, nil
+	}
+	c, err := app.cli.ContainerInspect(context.Background(), proc.ID)
+	if err != nil {
+		return ref, "", err
+	}
+	image, _, err := app.cli.ImageInspectWithRaw(context.Background(), c.Image)
+	if err != nil {
+		return ref, "", err
+	}
+	return ref, digestFromRepoDigests(ref, image.RepoDigests), nil
+}
+
+// pinnedImage returns the pinned reference for the service, as long as the
+// pin still refers to the image given in the definition, including its tag.
+// Images given by digest in the definition are never replaced.
+func (app *App) pinnedImage(name string, service Service) string {
+	app.mu.RLock()
+	pinned, ok := app.Images[name]
+	app.mu.RUnlock()
+	if !ok {
+		return service.GetImage()
+	}
+	ref, err := ParseImageReference(pinned)
+	if err != nil {
+		return service.GetImage()
+	}
+	current, err := ParseImageReference(service.GetImage())
+	if err != nil || current.Digest != "" {
+		return service.GetImage()
+	}
+	if ref.Normalized().WithDigest("") != current.Normalized() {
+		logging.Info(fmt.Sprintf("ignoring pinned image %s of %s, as the definition now uses %s, run pin again to update it",
+			pinned, name, service.GetImage()))
+		return service.GetImage()
+	}
+	return pinned
+}
+
 func (app *App) start(writer io.Writer) error {
 	data, err := ioutil.ReadFile("config.yaml")
 	if err != nil {
@@ -310,75 +667,104 @@ func (app *App) start(writer io.Writer) error {
 	}
 
 	var definition Definition
-	return utils.HandleErrors(utils.ReturnError,
-		yaml.Unmarshal(data, &definition),
-		app.createNetworks(),
-		app.registerVolumes(definition.Services),
-		app.createProcesses(definition.Services),
-		app.ps(writer),
-	)
+	if err := yaml.Unmarshal(data, &definition); err != nil {
+		return err
+	}
+	return app.startWithDefinition(definition, writer)
 }
 
 func (app *App) startWithDefinition(definition Definition, writer io.Writer) error {
-	return utils.HandleErrors(utils.ReturnError,
-		app.createNetworks(),
-		app.registerVolumes(definition.Services),
-		app.createProcesses(definition.Services),
-		app.ps(writer),
-	)
+	services, err := app.resolveServices(definition)
+	if err != nil {
+		return err
+	}
+	// the services are validated before any resource is created, and every
+	// step depends on the previous one, so none are run after a failure
+	if err := app.validateServices(services); err != nil {
+		return err
+	}
+	if err := app.createNetworks(); err != nil {
+		return err
+	}
+	if err := app.registerExternalNetworks(definition); err != nil {
+		return err
+	}
+	if err := app.registerVolumes(definition); err != nil {
+		return err
+	}
+	if err := app.createProcesses(services); err != nil {
+		return err
+	}
+	return app.ps(writer, RunOptions{})
 }
 
 func (app *App) createNetworks() error {
 	if app.NetworkID != "" {
 		if _, err := app.cli.NetworkInspect(context.Background(), app.NetworkID, types.NetworkInspectOptions{}); err == nil {
-			fmt.Println("Network already created: gompose-network")
+			fmt.Printf("Network already created: %s\n", app.networkName())
 			return nil
 		}
 	}
-	log.Println("Creating network: gompose-network")
-	netdriver, err := app.cli.NetworkCreate(context.Background(), "gompose-network", types.NetworkCreate{
+	log.Printf("Creating network: %s\n", app.networkName())
+	netdriver, err := app.cli.NetworkCreate(context.Background(), app.networkName(), types.NetworkCreate{
 		CheckDuplicate: true,
 		Attachable:     true,
 	})
 	if err != nil {
 		return err
 	}
+	app.mu.Lock()
 	app.NetworkID = netdriver.ID
+	app.mu.Unlock()
+	app.publish(NetworkCreated, "", netdriver.ID, app.networkName())
 	return nil
 }
 
-func (app *App) registerVolumes(services map[string]Service) error {
-	for _, service := range services {
-		if err := app.createServiceVolumes(service); err != nil {
+func (app *App) registerVolumes(definition Definition) error {
+	for _, service := range definition.Services {
+		if err := app.createServiceVolumes(service, definition.Volumes); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (app *App) createServiceVolumes(service Service) error {
+func (app *App) createServiceVolumes(service Service, configs map[string]VolumeConfig) error {
 	for _, v := range service.Volumes {
 		vol, err := NewVolume(v)
 		if err != nil {
 			return err
 		}
-		if err := app.createDockerVolume(vol); err != nil {
+		config := configs[vol.Source]
+		if config.External {
+			err = app.registerExternalVolume(vol.Source, config.GetName(vol.Source))
+		} else {
+			err = app.createDockerVolume(vol, config.Name)
+		}
+		if err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (app *App) createDockerVolume(vol Volume) error {
-	if _, ok := app.Volumes[vol.Source]; !ok {
+func (app *App) createDockerVolume(vol Volume, name string) error {
+	app.mu.RLock()
+	_, ok := app.Volumes[vol.Source]
+	app.mu.RUnlock()
+	if !ok {
 		log.Printf("creating local volume: %s\n", vol.Source)
 		v, err := app.cli.VolumeCreate(context.Background(), volume.VolumeCreateBody{
+			Name:   name,
 			Driver: "local",
 		})
 		if err != nil {
 			return err
 		}
+		app.mu.Lock()
 		app.Volumes[vol.Source] = v.Name
+		app.mu.Unlock()
+		app.publish(VolumeCreated, "", v.Name, vol.Source)
 	}
 	return nil
 }
@@ -390,51 +776,94 @@ func logContainerStatus(name, status string, final bool) {
 	}
 }
 
+// validateServices reports the invalid services of the definition. The
+// volumes are only created once it is valid, so every volume of the services
+// is assumed to exist.
+func (app *App) validateServices(services map[string]Service) error {
+	volumes := app.volumeSources()
+	for _, service := range services {
+		for _, v := range service.Volumes {
+			if vol, err := NewVolume(v); err == nil {
+				if _, ok := volumes[vol.Source]; !ok {
+					volumes[vol.Source] = vol.Source
+				}
+			}
+		}
+	}
+	var errs []error
+	for name, service := range services {
+		if err := app.checkHostAccess(service); err != nil {
+			errs = append(errs, fmt.Errorf("service %s: %v", name, err))
+		}
+		if DriverFromString(service.Driver) == EXEC {
+			continue
+		}
+		errs = append(errs, app.newContainerBuilder(name, service, volumes).Err())
+	}
+	return utils.CombineErrors(errs...)
+}
+
+// createProcesses creates and starts the services. A service is only started
+// once the services it depends on have been started, or have failed to start.
 func (app *App) createProcesses(services map[string]Service) error {
-	app.wg.Add(len(services))
+	started := make(map[string]chan struct{}, len(services))
+	for name := range services {
+		started[name] = make(chan struct{})
+	}
+	// the services waiting for their dependencies are not started when a
+	// later service can't be created
+	abort := make(chan struct{})
 	for name, service := range services {
 		start, err := app.createProcess(name, service)
 		if err != nil {
-			app.wg.Done()
+			close(abort)
 			return err
 		}
+		app.wg.Add(1)
 		if len(service.DependsOn) == 0 {
 			app.invokeStart(start, name)
+			close(started[name])
 			continue
 		}
-		go func() {
-			app.waitForDependencies(service, name)
+		go func(name string, service Service, start func() error) {
+			defer close(started[name])
+			for _, dep := range service.DependsOn {
+				// dependencies outside of the definition are not waited for
+				ready, ok := started[dep]
+				if !ok {
+					continue
+				}
+				select {
+				case <-ready:
+				case <-abort:
+					app.wg.Done()
+					return
+				}
+			}
 			app.invokeStart(start, name)
-		}()
+		}(name, service, start)
 	}
 	return nil
 }
 
-func (app *App) waitForDependencies(service Service, name string) {
-	for _, srv := range service.DependsOn {
-		if !app.IsServiceRunning(srv) {
-			dep := NewDependency(srv)
-			app.AddDependency(dep)
-			dep.Wait()
-		}
-	}
-}
-
 func (app *App) invokeStart(start func() error, name string) {
-	app.serviceDone <- name
+	app.publish(ServiceStarting, name, "", "")
 	if err := start(); err != nil {
 		log.Println(err)
+		app.publish(ServiceFailed, name, "", err.Error())
+	} else {
+		app.publish(ServiceRunning, name, "", "")
 	}
 	logContainerStatus(name, "RUNNING", true)
 	app.wg.Done()
 }
 
 func (app *App) IsServiceRunning(service string) bool {
-	s, ok := app.Containers[service]
+	s, ok := app.container(service)
 	if ok && s.Status == RUNNING {
 		return true
 	}
-	p, ok := app.Processes[service]
+	p, ok := app.process(service)
 	if ok && p.Status == RUNNING {
 		return true
 	}
@@ -449,32 +878,109 @@ func (app *App) createProcess(name string, service Service) (func() error, error
 }
 
 func (app *App) createExecProcess(name string, service Service) (func() error, error) {
-	if _, ok := app.Processes[name]; ok {
+	if proc, ok := app.process(name); ok && proc.Status == RUNNING {
 		return nilfn, nil
 	}
-	cmds := strings.Split(service.Command, " ")
-	cmd := exec.Command(cmds[0], cmds[1:]...)
+	cmd, err := newExecCommand(service)
+	if err != nil {
+		return nilfn, fmt.Errorf("invalid definition for service %s: %v", name, err)
+	}
+	setProcessGroup(cmd)
 
 	return func() error {
-		if err := cmd.Start(); err != nil {
+		uid, gid := commandOwner(cmd)
+		filesDir, env, err := writeServiceFiles(name, service.files, uid, gid)
+		if err != nil {
+			return fmt.Errorf("could not write the secrets and configs of %s: %v", name, err)
+		}
+		cmd.Env = append(cmd.Env, env...)
+		logFile, err := app.openProcessLog(name)
+		if err != nil {
+			removeServiceFiles(filesDir)
+			return err
+		}
+		cmd.Stdout = logFile
+		cmd.Stderr = logFile
+		var stdin io.WriteCloser
+		if service.StdinOpen {
+			if stdin, err = cmd.StdinPipe(); err != nil {
+				logFile.Close()
+				removeServiceFiles(filesDir)
+				return err
+			}
+		}
+		// startProcess reaps the process itself when its limits can't be
+		// applied, which closes the stdin pipe
+		release, err := startProcess(app.project, name, cmd, service)
+		if err != nil {
+			logFile.Close()
+			removeServiceFiles(filesDir)
 			return fmt.Errorf("could not start process %s: %v, %v", cmd.Path, cmd.Args, err)
 		}
-		app.Processes[name] = Process{
-			ID:         fmt.Sprintf("%d", cmd.Process.Pid),
-			PID:        cmd.Process.Pid,
-			Driver:     EXEC,
-			Status:     RUNNING,
-			OnStop:     service.OnStop,
-			StopSignal: service.StopSignal,
+		if stdin != nil {
+			app.setStdin(name, stdin)
+		}
+		pid := cmd.Process.Pid
+		startTime, cmdline, err := processIdentity(pid)
+		if err != nil && err != errIdentityUnsupported {
+			log.Println(err)
 		}
+		gracePeriod, _ := service.GetStopGracePeriod()
+		// the process is stored before waiting for it, so that it is marked
+		// as stopped however soon it exits
+		app.setProcess(name, Process{
+			ID:              fmt.Sprintf("%d", pid),
+			PID:             pid,
+			PGID:            pid,
+			StartTime:       startTime,
+			CmdlineHash:     hashCmdline(cmdline),
+			Driver:          EXEC,
+			Status:          RUNNING,
+			OnStop:          service.OnStop,
+			StopSignal:      service.StopSignal,
+			StopGracePeriod: gracePeriod,
+			DependsOn:       service.DependsOn,
+			FilesDir:        filesDir,
+			SensitiveEnv:    service.sensitiveEnv,
+		})
+		go func() {
+			err := cmd.Wait()
+			logFile.Close()
+			removeServiceFiles(filesDir)
+			release()
+			if stdin != nil {
+				app.deleteStdin(name, stdin)
+			}
+			app.setProcessStopped(name, pid)
+			message := "process exited"
+			if err != nil {
+				message = err.Error()
+			}
+			app.publish(ServiceExited, name, fmt.Sprintf("%d", pid), message)
+		}()
 		return nil
 	}, nil
 }
 
 func nilfn() error { return nil }
 
+// pullImage displays the pull progress on stdout, while publishing it on the
+// event bus.
+func (app *App) pullImage(name string, reader io.ReadCloser) {
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		app.publishPullProgress(name, pr)
+		close(done)
+	}()
+	readToStdOut(ioutil.NopCloser(io.TeeReader(reader, pw)))
+	pw.Close()
+	<-done
+	reader.Close()
+}
+
 func (app *App) createContainer(name string, service Service) (func() error, error) {
-	if proc, ok := app.Containers[name]; ok {
+	if proc, ok := app.container(name); ok {
 		if proc.Status != STOPPED {
 			return nilfn, nil
 		}
@@ -483,49 +989,81 @@ func (app *App) createContainer(name string, service Service) (func() error, err
 				return err
 			}
 			proc.Status = RUNNING
-			app.Containers[name] = proc
+			app.setContainer(name, proc)
 			return nil
 		}, nil
 	}
 	return app.createNewContainer(name, service)
 }
 
-func (app *App) createNewContainer(name string, service Service) (func() error, error) {
-	logContainerStatus(name, "PENDING", false)
-	builder := NewContainerBuilder(name).
+func (app *App) newContainerBuilder(name string, service Service, volumes map[string]string) ContainerBuilder {
+	return NewContainerBuilder(name).
+		SetContainerName(app.containerName(name)).
 		SetConfig(service).
+		AddLabels(app.labels(name)).
 		AddRestartPolicy(service).
-		AddVolumes(service, app.Volumes).
-		AddPortBindings(service)
+		AddVolumes(service, volumes).
+		AddPortBindings(service).
+		AddResources(service).
+		AddRuntimeOptions(service)
+}
+
+func (app *App) createNewContainer(name string, service Service) (func() error, error) {
+	logContainerStatus(name, "PENDING", false)
+	app.publish(ServiceCreating, name, "", service.Image)
+	service.Image = app.pinnedImage(name, service)
+	filesDir, secrets, err := writeContainerSecrets(name, service.files)
+	if err != nil {
+		return nilfn, fmt.Errorf("could not write the secrets of %s: %v", name, err)
+	}
+	builder := app.newContainerBuilder(name, service, app.volumeSources()).AddMounts(secrets)
 
 	c, err := builder.Build(app.cli)
 	if err != nil {
 		if !strings.Contains(err.Error(), "No such image") {
+			removeServiceFiles(filesDir)
 			return nilfn, err
 		}
 		reader, err := app.cli.ImagePull(context.Background(), service.GetImage(), types.ImagePullOptions{})
 		if err != nil {
+			removeServiceFiles(filesDir)
 			return nilfn, err
 		}
-		readToStdOut(reader)
+		app.pullImage(name, reader)
 		c, err = builder.Build(app.cli)
 		if err != nil {
 			fmt.Println("oh shit it's down here?")
+			removeServiceFiles(filesDir)
 			return nilfn, err
 		}
 	}
-	app.Containers[name] = Process{
-		ID:         c.ID,
-		Driver:     DOCKER,
-		Status:     RUNNING,
-		StopSignal: service.StopSignal,
+	gracePeriod, _ := service.GetStopGracePeriod()
+	app.setContainer(name, Process{
+		ID:              c.ID,
+		Driver:          DOCKER,
+		Status:          RUNNING,
+		StopSignal:      service.StopSignal,
+		StopGracePeriod: gracePeriod,
+		DependsOn:       service.DependsOn,
+		Image:           service.Image,
+		FilesDir:        filesDir,
+		SensitiveEnv:    service.sensitiveEnv,
+	})
+	if err := app.copyFilesToContainer(context.Background(), c.ID, service.files); err != nil {
+		return nilfn, fmt.Errorf("could not copy the configs of %s: %v", name, err)
 	}
 	logContainerStatus(name, "CREATED", false)
+	app.publish(ServiceCreated, name, c.ID, "")
 
-	if err := app.cli.NetworkConnect(context.Background(), app.NetworkID, c.ID, nil); err != nil {
+	if err := app.cli.NetworkConnect(context.Background(), app.NetworkID, c.ID, &network.EndpointSettings{
+		Aliases: []string{name},
+	}); err != nil {
 		return nilfn, fmt.Errorf("[container: %s, network: %s] network connect returned with status: %v",
 			c.ID, app.NetworkID, err)
 	}
+	if err := app.connectExternalNetworks(context.Background(), c.ID, name, service.Networks); err != nil {
+		return nilfn, err
+	}
 	return func() error {
 		return app.cli.ContainerStart(context.Background(), c.ID, types.ContainerStartOptions{})
 	}, nil
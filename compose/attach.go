@@ -0,0 +1,394 @@
+package compose
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+var errExecDisabled = errors.New("the EXEC driver is disabled on this server")
+
+// checkHostAccess refuses the services giving access to the host when the
+// EXEC driver is disabled: EXEC services, and DOCKER services with the
+// privileges or devices of the host.
+func (app *App) checkHostAccess(service Service) error {
+	if app.execEnabled {
+		return nil
+	}
+	if DriverFromString(service.Driver) == EXEC {
+		return errExecDisabled
+	}
+	var options []string
+	if service.Privileged {
+		options = append(options, "privileged")
+	}
+	if len(service.CapAdd) != 0 {
+		options = append(options, "cap_add")
+	}
+	if len(service.Devices) != 0 {
+		options = append(options, "devices")
+	}
+	if len(options) != 0 {
+		return fmt.Errorf("the %s options are disabled on this server, as the EXEC driver is disabled", strings.Join(options, ", "))
+	}
+	return nil
+}
+
+// Exec runs a command inside the running container of a DOCKER service, or
+// as a new process in the environment of an EXEC service, returning its exit
+// code. EXEC services are run without a terminal.
+func (app *App) Exec(ctx context.Context, definition Definition, name string, options ExecOptions, streams Streams) (int, error) {
+	service, ok := definition.Services[name]
+	if !ok {
+		return 0, fmt.Errorf("no such service: %v", name)
+	}
+	if len(options.Cmd) == 0 {
+		return 0, fmt.Errorf("no command specified")
+	}
+	if DriverFromString(service.Driver) == EXEC {
+		if !app.execEnabled {
+			return 0, errExecDisabled
+		}
+		service, err := app.resolveService(definition, service)
+		if err != nil {
+			return 0, err
+		}
+		return runServiceCommand(ctx, service, options, streams)
+	}
+	proc, ok := app.container(name)
+	if !ok || proc.Status != RUNNING {
+		return 0, fmt.Errorf("service %s is not running", name)
+	}
+
+	created, err := app.cli.ContainerExecCreate(ctx, proc.ID, types.ExecConfig{
+		User:         options.User,
+		Tty:          options.Tty,
+		AttachStdin:  options.Stdin,
+		AttachStdout: true,
+		AttachStderr: true,
+		Env:          options.Env,
+		WorkingDir:   options.WorkingDir,
+		Cmd:          options.Cmd,
+	})
+	if err != nil {
+		return 0, err
+	}
+	resp, err := app.cli.ContainerExecAttach(ctx, created.ID, types.ExecStartCheck{Tty: options.Tty})
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Close()
+	streams.resize(func(size TerminalSize) {
+		app.cli.ContainerExecResize(ctx, created.ID, types.ResizeOptions{Height: size.Height, Width: size.Width})
+	})
+	if err := pumpStreams(ctx, resp, options, streams); err != nil {
+		return 0, err
+	}
+	inspect, err := app.cli.ContainerExecInspect(ctx, created.ID)
+	if err != nil {
+		return 0, err
+	}
+	return inspect.ExitCode, nil
+}
+
+// RunOnce runs a command in a new container created from the definition of
+// the service, connected to the network of the project, returning its exit
+// code. The ports of the service are not published, so that it can run
+// alongside the service. For EXEC services, the command is run as a new
+// process in the environment of the service.
+func (app *App) RunOnce(ctx context.Context, definition Definition, name string, options ExecOptions, streams Streams) (int, error) {
+	service, ok := definition.Services[name]
+	if !ok {
+		return 0, fmt.Errorf("no such service: %v", name)
+	}
+	// both EXEC services and bind mounts give access to the host
+	if err := app.checkHostAccess(service); err != nil {
+		return 0, err
+	}
+	if !app.execEnabled && len(options.Volumes) != 0 {
+		return 0, fmt.Errorf("bind mounts are disabled on this server, as the EXEC driver is disabled")
+	}
+	service, err := app.resolveService(definition, service)
+	if err != nil {
+		return 0, err
+	}
+	if DriverFromString(service.Driver) == EXEC {
+		if len(options.Cmd) == 0 {
+			options.Cmd = strings.Fields(service.Command)
+		}
+		return runServiceCommand(ctx, service, options, streams)
+	}
+
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		return 0, err
+	}
+	service.Image = app.pinnedImage(name, service)
+	filesDir, secrets, err := writeContainerSecrets("run", service.files)
+	if err != nil {
+		return 0, err
+	}
+	// the secrets are removed once the command has exited, even if the
+	// container is kept
+	defer removeServiceFiles(filesDir)
+	builder := NewContainerBuilder(name).
+		SetContainerName(fmt.Sprintf("%s_run_%x", app.containerName(name), suffix)).
+		SetConfig(service).
+		AddLabels(app.labels(name)).
+		AddVolumes(service, app.volumeSources()).
+		AddMounts(secrets).
+		AddResources(service).
+		AddRuntimeOptions(service).
+		SetInteractive(options.Tty, options.Stdin).
+		SetCommand(options.Cmd).
+		SetEntrypoint(options.Entrypoint).
+		AddEnv(options.Env).
+		AddBinds(options.Volumes).
+		SetUser(options.User).
+		SetWorkingDir(options.WorkingDir)
+
+	c, err := builder.Build(app.cli)
+	if err != nil {
+		if !strings.Contains(err.Error(), "No such image") {
+			return 0, err
+		}
+		reader, err := app.cli.ImagePull(ctx, service.GetImage(), types.ImagePullOptions{})
+		if err != nil {
+			return 0, err
+		}
+		app.pullImage(name, reader)
+		if c, err = builder.Build(app.cli); err != nil {
+			return 0, err
+		}
+	}
+	if options.Remove {
+		defer app.cli.ContainerRemove(context.Background(), c.ID, types.ContainerRemoveOptions{Force: true})
+	}
+	if err := app.copyFilesToContainer(ctx, c.ID, service.files); err != nil {
+		return 0, err
+	}
+
+	app.mu.RLock()
+	networkID := app.NetworkID
+	app.mu.RUnlock()
+	if networkID != "" {
+		if err := app.cli.NetworkConnect(ctx, networkID, c.ID, &network.EndpointSettings{}); err != nil {
+			return 0, err
+		}
+	}
+	if err := app.connectExternalNetworks(ctx, c.ID, "", service.Networks); err != nil {
+		return 0, err
+	}
+
+	resp, err := app.cli.ContainerAttach(ctx, c.ID, types.ContainerAttachOptions{
+		Stream: true,
+		Stdin:  options.Stdin,
+		Stdout: true,
+		Stderr: true,
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Close()
+	statusC, errC := app.cli.ContainerWait(ctx, c.ID, container.WaitConditionNextExit)
+	if err := app.cli.ContainerStart(ctx, c.ID, types.ContainerStartOptions{}); err != nil {
+		return 0, err
+	}
+	streams.resize(func(size TerminalSize) {
+		app.cli.ContainerResize(ctx, c.ID, types.ResizeOptions{Height: size.Height, Width: size.Width})
+	})
+	if err := pumpStreams(ctx, resp, options, streams); err != nil {
+		return 0, err
+	}
+	select {
+	case status := <-statusC:
+		if status.Error != nil {
+			return 0, errors.New(status.Error.Message)
+		}
+		return int(status.StatusCode), nil
+	case err := <-errC:
+		return 0, err
+	}
+}
+
+// resize calls fn for every change of the terminal size, until the channel
+// is closed.
+func (streams Streams) resize(fn func(size TerminalSize)) {
+	if streams.Resize == nil {
+		return
+	}
+	go func() {
+		for size := range streams.Resize {
+			fn(size)
+		}
+	}()
+}
+
+// pumpStreams copies stdin to the attached connection, and its output to
+// stdout and stderr until the command exits or the context is cancelled.
+// Output is multiplexed unless a terminal is allocated.
+func pumpStreams(ctx context.Context, resp types.HijackedResponse, options ExecOptions, streams Streams) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			resp.Close()
+		case <-done:
+		}
+	}()
+	if options.Stdin && streams.Stdin != nil {
+		go func() {
+			io.Copy(resp.Conn, streams.Stdin)
+			resp.CloseWrite()
+		}()
+	}
+	var err error
+	if options.Tty {
+		_, err = io.Copy(streams.Stdout, resp.Reader)
+	} else {
+		_, err = stdcopy.StdCopy(streams.Stdout, streams.Stderr, resp.Reader)
+	}
+	return err
+}
+
+func runServiceCommand(ctx context.Context, service Service, options ExecOptions, streams Streams) (int, error) {
+	if len(options.Cmd) == 0 {
+		return 0, fmt.Errorf("no command specified")
+	}
+	if options.User != "" {
+		service.User = options.User
+	}
+	if options.WorkingDir != "" {
+		service.WorkingDir = options.WorkingDir
+	}
+	service.Env = append(service.Env, options.Env...)
+	cmd, err := newServiceCommand(service, options.Cmd)
+	if err != nil {
+		return 0, err
+	}
+	uid, gid := commandOwner(cmd)
+	filesDir, env, err := writeServiceFiles("run", service.files, uid, gid)
+	if err != nil {
+		return 0, err
+	}
+	defer removeServiceFiles(filesDir)
+	cmd.Env = append(cmd.Env, env...)
+	cmd.Stdout = streams.Stdout
+	cmd.Stderr = streams.Stderr
+	// stdin is copied by hand, as exec.Cmd would otherwise wait for it to be
+	// closed before returning from Wait.
+	var stdin io.WriteCloser
+	if options.Stdin && streams.Stdin != nil {
+		if stdin, err = cmd.StdinPipe(); err != nil {
+			return 0, err
+		}
+	}
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	if stdin != nil {
+		go func() {
+			io.Copy(stdin, streams.Stdin)
+			stdin.Close()
+		}()
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			cmd.Process.Kill()
+		case <-done:
+		}
+	}()
+	if err := cmd.Wait(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return exitErr.ExitCode(), nil
+		}
+		return 0, err
+	}
+	return 0, nil
+}
+
+// Attach connects the streams to the main process of a running service, until
+// the client detaches or the process exits, returning its exit code. Clients
+// detach from containers with ctrl-p ctrl-q. EXEC processes only accept input
+// when started with stdin_open by this server, and their output is followed
+// from their log file.
+func (app *App) Attach(ctx context.Context, definition Definition, name string, options ExecOptions, streams Streams) (int, error) {
+	if _, ok := definition.Services[name]; !ok {
+		return 0, fmt.Errorf("no such service: %v", name)
+	}
+	if proc, ok := app.process(name); ok {
+		return app.attachProcess(ctx, name, proc, options, streams)
+	}
+	proc, ok := app.container(name)
+	if !ok || proc.Status != RUNNING {
+		return 0, fmt.Errorf("service %s is not running", name)
+	}
+	inspect, err := app.cli.ContainerInspect(ctx, proc.ID)
+	if err != nil {
+		return 0, err
+	}
+	options.Tty = inspect.Config.Tty
+	options.Stdin = options.Stdin && inspect.Config.OpenStdin
+	resp, err := app.cli.ContainerAttach(ctx, proc.ID, types.ContainerAttachOptions{
+		Stream:     true,
+		Stdin:      options.Stdin,
+		Stdout:     true,
+		Stderr:     true,
+		DetachKeys: "ctrl-p,ctrl-q",
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Close()
+	streams.resize(func(size TerminalSize) {
+		app.cli.ContainerResize(ctx, proc.ID, types.ResizeOptions{Height: size.Height, Width: size.Width})
+	})
+	if err := pumpStreams(ctx, resp, options, streams); err != nil {
+		return 0, err
+	}
+	if inspect, err = app.cli.ContainerInspect(context.Background(), proc.ID); err != nil {
+		return 0, err
+	}
+	if inspect.State.Running {
+		return 0, nil
+	}
+	return inspect.State.ExitCode, nil
+}
+
+func (app *App) attachProcess(ctx context.Context, name string, proc Process, options ExecOptions, streams Streams) (int, error) {
+	if proc.Status != RUNNING {
+		return 0, fmt.Errorf("service %s is not running", name)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events, unsubscribe := app.events.Subscribe()
+	defer unsubscribe()
+	go func() {
+		for event := range events {
+			if event.Service == name && event.Type == ServiceExited {
+				cancel()
+			}
+		}
+	}()
+
+	if stdin, ok := app.stdin(name); ok && options.Stdin && streams.Stdin != nil {
+		// the stdin of the process is left open when the client detaches
+		go io.Copy(stdin, streams.Stdin)
+	} else if options.Stdin {
+		fmt.Fprintf(streams.Stderr, "stdin of %s is not attachable, only its output is shown\n", name)
+	}
+	return 0, app.processLogs(ctx, name, LogOptions{Follow: true, Tail: "0"}, streams.Stdout)
+}
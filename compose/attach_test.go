@@ -0,0 +1,32 @@
+package compose
+
+import "testing"
+
+func TestCheckHostAccess(t *testing.T) {
+	tests := []struct {
+		name    string
+		service Service
+		refused bool
+	}{
+		{name: "docker", service: Service{Image: "postgres"}},
+		{name: "exec", service: Service{Driver: "EXEC", Command: "./web"}, refused: true},
+		{name: "privileged", service: Service{Image: "postgres", Privileged: true}, refused: true},
+		{name: "cap_add", service: Service{Image: "postgres", CapAdd: []string{"SYS_ADMIN"}}, refused: true},
+		{name: "devices", service: Service{Image: "postgres", Devices: []string{"/dev/sda:/dev/sda"}}, refused: true},
+		{name: "cap_drop", service: Service{Image: "postgres", CapDrop: []string{"ALL"}}},
+	}
+	for _, test := range tests {
+		enabled := &App{execEnabled: true}
+		if err := enabled.checkHostAccess(test.service); err != nil {
+			t.Errorf("%s: refused with the EXEC driver enabled: %v", test.name, err)
+		}
+		disabled := &App{}
+		err := disabled.checkHostAccess(test.service)
+		if test.refused && err == nil {
+			t.Errorf("%s: expected to be refused with the EXEC driver disabled", test.name)
+		}
+		if !test.refused && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+	}
+}
@@ -3,35 +3,66 @@ package compose
 import (
 	"context"
 	"fmt"
+	"strings"
+
+	"github.com/Pungyeon/docker-gompose/utils"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/strslice"
 	"github.com/docker/docker/client"
 )
 
 type ContainerBuilder struct {
-	err        error
+	errs       []error
 	ctx        context.Context
 	config     *container.Config
 	hostconfig *container.HostConfig
 	name       string
+	// containerName defaults to the name of the service
+	containerName string
 }
 
 func NewContainerBuilder(name string) ContainerBuilder {
 	return ContainerBuilder{
-		ctx:        context.Background(),
-		config:     &container.Config{},
-		hostconfig: &container.HostConfig{},
-		name:       name,
+		ctx:           context.Background(),
+		config:        &container.Config{},
+		hostconfig:    &container.HostConfig{},
+		name:          name,
+		containerName: name,
 	}
 }
 
+func (builder ContainerBuilder) SetContainerName(name string) ContainerBuilder {
+	builder.containerName = name
+	return builder
+}
+
 func (builder ContainerBuilder) SetConfig(service Service) ContainerBuilder {
 	builder.config = &container.Config{
 		Hostname:   builder.name,
 		Image:      service.Image,
 		Env:        service.Env,
 		Entrypoint: service.GetEntrypoint(),
+		User:       service.User,
+		WorkingDir: service.WorkingDir,
+		Labels:     service.Labels,
+		Tty:        service.Tty,
+		OpenStdin:  service.StdinOpen,
+	}
+	return builder
+}
+
+// AddLabels adds the labels to those of the service, without modifying the
+// labels of the service itself.
+func (builder ContainerBuilder) AddLabels(labels map[string]string) ContainerBuilder {
+	merged := make(map[string]string, len(builder.config.Labels)+len(labels))
+	for key, value := range builder.config.Labels {
+		merged[key] = value
 	}
+	for key, value := range labels {
+		merged[key] = value
+	}
+	builder.config.Labels = merged
 	return builder
 }
 
@@ -39,6 +70,9 @@ func (builder ContainerBuilder) AddRestartPolicy(service Service) ContainerBuild
 	if 	service.RestartPolicy.Condition == "" {
 		return builder
 	}
+	if err := service.RestartPolicy.Validate(); err != nil {
+		return builder.addError(err)
+	}
 	builder.hostconfig.RestartPolicy = service.RestartPolicy.ToDockerPolicy()
 	return builder
 }
@@ -46,36 +80,126 @@ func (builder ContainerBuilder) AddRestartPolicy(service Service) ContainerBuild
 func (builder ContainerBuilder) AddVolumes(service Service, volumes map[string]string) ContainerBuilder {
 	mounts, err := parseVolumes(service, volumes)
 	if err != nil {
-		builder.err = err
-		return builder
+		return builder.addError(err)
 	}
 	builder.hostconfig.Mounts = append(builder.hostconfig.Mounts, mounts...)
 	return builder
 }
 
+// AddMounts adds mounts which are not declared by the service, such as its
+// secrets.
+func (builder ContainerBuilder) AddMounts(mounts []mount.Mount) ContainerBuilder {
+	builder.hostconfig.Mounts = append(builder.hostconfig.Mounts, mounts...)
+	return builder
+}
+
 func (builder ContainerBuilder) AddPortBindings(service Service) ContainerBuilder {
-	if builder.err != nil {
-		return builder
-	}
 	binds, err := service.GetPortBindings()
 	if err != nil {
-		builder.err = err
-		return builder
+		return builder.addError(err)
 	}
 	builder.hostconfig.PortBindings = binds
 	return builder
 }
 
+func (builder ContainerBuilder) AddResources(service Service) ContainerBuilder {
+	resources, err := service.GetResources()
+	if err != nil {
+		return builder.addError(err)
+	}
+	builder.hostconfig.Resources = resources
+	return builder
+}
+
+func (builder ContainerBuilder) AddRuntimeOptions(service Service) ContainerBuilder {
+	shmSize, err := service.GetShmSize()
+	if err != nil {
+		return builder.addError(err)
+	}
+	builder.hostconfig.ShmSize = shmSize
+	builder.hostconfig.CapAdd = service.CapAdd
+	builder.hostconfig.CapDrop = service.CapDrop
+	builder.hostconfig.Privileged = service.Privileged
+	builder.hostconfig.Sysctls = service.Sysctls
+	builder.hostconfig.ExtraHosts = service.ExtraHosts
+	builder.hostconfig.DNS = service.DNS
+	builder.hostconfig.Init = service.Init
+	builder.hostconfig.ReadonlyRootfs = service.ReadOnly
+	builder.hostconfig.SecurityOpt = service.SecurityOpt
+	return builder
+}
+
+// SetInteractive allocates a terminal and keeps stdin open, for containers
+// that are attached to.
+func (builder ContainerBuilder) SetInteractive(tty bool, stdin bool) ContainerBuilder {
+	builder.config.Tty = tty
+	builder.config.OpenStdin = stdin
+	builder.config.StdinOnce = stdin
+	builder.config.AttachStdin = stdin
+	builder.config.AttachStdout = true
+	builder.config.AttachStderr = true
+	return builder
+}
+
+func (builder ContainerBuilder) SetCommand(cmd []string) ContainerBuilder {
+	if len(cmd) != 0 {
+		builder.config.Cmd = cmd
+	}
+	return builder
+}
+
+// SetEntrypoint overrides the entrypoint of the service, if not empty.
+func (builder ContainerBuilder) SetEntrypoint(entrypoint string) ContainerBuilder {
+	if entrypoint != "" {
+		builder.config.Entrypoint = strslice.StrSlice{entrypoint}
+	}
+	return builder
+}
+
+func (builder ContainerBuilder) SetUser(user string) ContainerBuilder {
+	if user != "" {
+		builder.config.User = user
+	}
+	return builder
+}
+
+func (builder ContainerBuilder) SetWorkingDir(dir string) ContainerBuilder {
+	if dir != "" {
+		builder.config.WorkingDir = dir
+	}
+	return builder
+}
+
+func (builder ContainerBuilder) AddEnv(env []string) ContainerBuilder {
+	builder.config.Env = append(builder.config.Env, env...)
+	return builder
+}
+
+// AddBinds mounts host paths given as host:container[:ro].
+func (builder ContainerBuilder) AddBinds(binds []string) ContainerBuilder {
+	for _, bind := range binds {
+		if len(strings.Split(bind, ":")) < 2 {
+			builder = builder.addError(fmt.Errorf("invalid volume, expected host:container[:ro]: %v", bind))
+			continue
+		}
+		builder.hostconfig.Binds = append(builder.hostconfig.Binds, bind)
+	}
+	return builder
+}
+
 func parseVolumes(service Service, volumes map[string]string) ([]mount.Mount, error) {
 	var mounts []mount.Mount
+	var errs []error
 	for _, v := range service.Volumes {
 		vol, err := NewVolume(v)
 		if err != nil {
-			return nil, err
+			errs = append(errs, err)
+			continue
 		}
 		source, ok := volumes[vol.Source]
 		if !ok {
-			return nil, fmt.Errorf("could not find volume source in parsed Volumes: %v", vol.Source)
+			errs = append(errs, fmt.Errorf("could not find volume source in parsed Volumes: %v", vol.Source))
+			continue
 		}
 
 		mounts = append(mounts, mount.Mount{
@@ -85,11 +209,28 @@ func parseVolumes(service Service, volumes map[string]string) ([]mount.Mount, er
 			ReadOnly: vol.ReadOnly,
 		})
 	}
-	return mounts, nil
+	return mounts, utils.CombineErrors(errs...)
+}
+
+func (builder ContainerBuilder) addError(err error) ContainerBuilder {
+	builder.errs = append(builder.errs, err)
+	return builder
+}
+
+// Err returns every configuration error encountered while building the
+// container, or nil if the definition is valid.
+func (builder ContainerBuilder) Err() error {
+	if err := utils.CombineErrors(builder.errs...); err != nil {
+		return fmt.Errorf("invalid definition for service %s: %v", builder.name, err)
+	}
+	return nil
 }
 
 func (builder ContainerBuilder) Build(cli *client.Client) (container.ContainerCreateCreatedBody, error) {
-	return cli.ContainerCreate(builder.ctx, builder.config, builder.hostconfig, nil, builder.name)
+	if err := builder.Err(); err != nil {
+		return container.ContainerCreateCreatedBody{}, err
+	}
+	return cli.ContainerCreate(builder.ctx, builder.config, builder.hostconfig, nil, builder.containerName)
 }
 
 func isReadOnly(vol []string) bool {
@@ -0,0 +1,85 @@
+package compose
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// The labels added to every container, identifying the project and service
+// that it was created for.
+const (
+	LabelProject = "com.gompose.project"
+	LabelService = "com.gompose.service"
+)
+
+func (app *App) labels(service string) map[string]string {
+	return map[string]string{
+		LabelProject: app.project,
+		LabelService: service,
+	}
+}
+
+// removeOrphans stops and removes the containers labelled for the project,
+// whose service is no longer in the definition. It returns the images of the
+// removed containers.
+func (app *App) removeOrphans(writer io.Writer, definition Definition, options RunOptions) []string {
+	list, err := app.cli.ContainerList(context.Background(), types.ContainerListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", LabelProject+"="+app.project)),
+	})
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	var images []string
+	for _, c := range list {
+		name := c.Labels[LabelService]
+		if _, ok := definition.Services[name]; ok {
+			continue
+		}
+		if proc, ok := app.container(name); ok && proc.ID == c.ID {
+			continue
+		}
+		app.publish(ServiceStopping, name, c.ID, "orphan")
+		timeout := options.stopTimeout(Process{})
+		if err := app.cli.ContainerStop(context.Background(), c.ID, &timeout); err != nil {
+			log.Println(err)
+		}
+		if err := app.cli.ContainerRemove(context.Background(), c.ID, types.ContainerRemoveOptions{Force: true}); err != nil {
+			log.Println(err)
+			continue
+		}
+		app.publish(ServiceRemoved, name, c.ID, "orphan")
+		writer.Write([]byte(fmt.Sprintf("Removed orphan %s [%v]\n", name, c.ID)))
+		images = append(images, c.Image)
+	}
+	return images
+}
+
+// removeImages removes the images used by the project. Unless all is set,
+// only local images, which have not been pulled from a registry, are removed.
+func (app *App) removeImages(writer io.Writer, images map[string]bool, all bool) {
+	for image := range images {
+		if !all {
+			inspect, _, err := app.cli.ImageInspectWithRaw(context.Background(), image)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			if len(inspect.RepoDigests) != 0 {
+				continue
+			}
+		}
+		if _, err := app.cli.ImageRemove(context.Background(), image, types.ImageRemoveOptions{PruneChildren: true}); err != nil {
+			log.Println(err)
+			continue
+		}
+		app.publish(ImageRemoved, "", image, "")
+		writer.Write([]byte(fmt.Sprintf("Removed image: %s\n", image)))
+	}
+}
@@ -0,0 +1,65 @@
+package compose
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Environment is the environment of a service, as KEY=VALUE entries. In the
+// definition, an entry is either given as KEY=VALUE, or as a mapping with the
+// name and value of the variable, and whether the value is sensitive.
+type Environment []string
+
+// EnvVar is an entry of the environment of a service.
+type EnvVar struct {
+	Name      string `yaml:"name"`
+	Value     string `yaml:"value"`
+	Sensitive bool   `yaml:"sensitive"`
+
+	// entry is the entry as given, when given as a string.
+	entry string
+}
+
+func (v *EnvVar) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var entry string
+	if err := unmarshal(&entry); err == nil {
+		name, value := splitEnv(entry)
+		*v = EnvVar{Name: name, Value: value, entry: entry}
+		return nil
+	}
+	type plain EnvVar
+	if err := unmarshal((*plain)(v)); err != nil {
+		return err
+	}
+	if v.Name == "" {
+		return fmt.Errorf("environment variable without a name")
+	}
+	return nil
+}
+
+func (v EnvVar) String() string {
+	if v.entry != "" {
+		return v.entry
+	}
+	return v.Name + "=" + v.Value
+}
+
+func (env *Environment) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var vars []EnvVar
+	if err := unmarshal(&vars); err != nil {
+		return err
+	}
+	*env = make(Environment, len(vars))
+	for i, v := range vars {
+		(*env)[i] = v.String()
+	}
+	return nil
+}
+
+func splitEnv(entry string) (string, string) {
+	parts := strings.SplitN(entry, "=", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
@@ -0,0 +1,85 @@
+package compose
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestEnvironmentUnmarshalYAML(t *testing.T) {
+	tests := []struct {
+		name      string
+		yaml      string
+		env       Environment
+		sensitive []string
+		err       bool
+	}{
+		{
+			name: "strings",
+			yaml: "env: [A=1, B=two=2, C]",
+			env:  Environment{"A=1", "B=two=2", "C"},
+		},
+		{
+			name:      "mappings",
+			yaml:      "env: [{name: A, value: '1'}, {name: TOKEN, value: abc, sensitive: true}]",
+			env:       Environment{"A=1", "TOKEN=abc"},
+			sensitive: []string{"TOKEN"},
+		},
+		{
+			name:      "mixed",
+			yaml:      "env: [A=1, {name: KEY, value: x, sensitive: true}]",
+			env:       Environment{"A=1", "KEY=x"},
+			sensitive: []string{"KEY"},
+		},
+		{
+			name:      "sensitive_env",
+			yaml:      "env: [A=1, {name: KEY, value: x, sensitive: true}]\nsensitive_env: [A, KEY]",
+			env:       Environment{"A=1", "KEY=x"},
+			sensitive: []string{"A", "KEY"},
+		},
+		{
+			name: "without name",
+			yaml: "env: [{value: x}]",
+			err:  true,
+		},
+	}
+	for _, test := range tests {
+		var service Service
+		err := yaml.Unmarshal([]byte(test.yaml), &service)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(service.Env, test.env) {
+			t.Errorf("%s: env = %q, expected %q", test.name, service.Env, test.env)
+		}
+		if !reflect.DeepEqual(service.SensitiveEnv, test.sensitive) {
+			t.Errorf("%s: sensitive_env = %q, expected %q", test.name, service.SensitiveEnv, test.sensitive)
+		}
+	}
+}
+
+func TestServiceSensitiveEnvRoundTrip(t *testing.T) {
+	var service Service
+	if err := yaml.Unmarshal([]byte("env: [{name: TOKEN, value: abc, sensitive: true}]"), &service); err != nil {
+		t.Fatal(err)
+	}
+	data, err := yaml.Marshal(service)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed Service
+	if err := yaml.Unmarshal(data, &parsed); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed.SensitiveEnv, []string{"TOKEN"}) {
+		t.Errorf("sensitive_env = %q after marshalling:\n%s", parsed.SensitiveEnv, data)
+	}
+}
@@ -0,0 +1,152 @@
+package compose
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+type EventType string
+
+const (
+	ServiceCreating EventType = "service.creating"
+	ServiceCreated  EventType = "service.created"
+	ServiceStarting EventType = "service.starting"
+	ServiceRunning  EventType = "service.running"
+	ServiceStopping EventType = "service.stopping"
+	ServiceExited   EventType = "service.exited"
+	ServiceRemoved  EventType = "service.removed"
+	ServiceFailed   EventType = "service.failed"
+	ImagePull       EventType = "image.pull"
+	ImageRemoved    EventType = "image.removed"
+	NetworkCreated  EventType = "network.created"
+	NetworkRemoved  EventType = "network.removed"
+	VolumeCreated   EventType = "volume.created"
+	VolumeRemoved   EventType = "volume.removed"
+)
+
+// LifecycleEvent describes a change to the environment. Service is empty for
+// events concerning the network and volumes. Operation identifies the command
+// during which the event was published, and is zero outside of commands.
+type LifecycleEvent struct {
+	Time      time.Time     `json:"time"`
+	Type      EventType     `json:"type"`
+	Service   string        `json:"service,omitempty"`
+	ID        string        `json:"id,omitempty"`
+	Message   string        `json:"message,omitempty"`
+	Progress  *PullProgress `json:"progress,omitempty"`
+	Operation uint64        `json:"operation,omitempty"`
+}
+
+type PullProgress struct {
+	Layer   string `json:"layer"`
+	Status  string `json:"status"`
+	Current int64  `json:"current"`
+	Total   int64  `json:"total"`
+}
+
+const subscriberBuffer = 256
+
+// EventBus fans out lifecycle events to every subscriber. Slow subscribers
+// miss events rather than blocking the operation publishing them.
+type EventBus struct {
+	mu          sync.Mutex
+	subscribers map[chan LifecycleEvent]struct{}
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subscribers: map[chan LifecycleEvent]struct{}{},
+	}
+}
+
+// Subscribe returns a channel receiving all future events, along with a
+// function that must be called to unsubscribe.
+func (bus *EventBus) Subscribe() (<-chan LifecycleEvent, func()) {
+	events := make(chan LifecycleEvent, subscriberBuffer)
+	bus.mu.Lock()
+	bus.subscribers[events] = struct{}{}
+	bus.mu.Unlock()
+
+	var once sync.Once
+	return events, func() {
+		once.Do(func() {
+			bus.mu.Lock()
+			delete(bus.subscribers, events)
+			bus.mu.Unlock()
+			close(events)
+		})
+	}
+}
+
+func (bus *EventBus) Publish(event LifecycleEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	for subscriber := range bus.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+}
+
+func (app *App) publish(eventType EventType, service, id, message string) {
+	app.events.Publish(LifecycleEvent{
+		Type:      eventType,
+		Service:   service,
+		ID:        id,
+		Message:   app.redactor.Redact(message),
+		Operation: app.queue.currentID(),
+	})
+}
+
+// Events returns the event bus on which all lifecycle changes are published.
+func (app *App) Events() *EventBus {
+	return app.events
+}
+
+// NewOperation returns the id of a new operation, to be given in RunOptions
+// before running a command, so that its events can be told apart.
+func (app *App) NewOperation() uint64 {
+	return app.queue.newID()
+}
+
+// publishPullProgress decodes the image pull stream and publishes the
+// progress of each layer. The reader is always drained.
+func (app *App) publishPullProgress(service string, reader io.Reader) {
+	defer io.Copy(ioutil.Discard, reader)
+	decoder := json.NewDecoder(reader)
+	for {
+		var msg jsonmessage.JSONMessage
+		if err := decoder.Decode(&msg); err != nil {
+			return
+		}
+		if msg.Error != nil {
+			app.publish(ServiceFailed, service, msg.ID, msg.Error.Message)
+			continue
+		}
+		progress := &PullProgress{
+			Layer:  msg.ID,
+			Status: msg.Status,
+		}
+		if msg.Progress != nil {
+			progress.Current = msg.Progress.Current
+			progress.Total = msg.Progress.Total
+		}
+		app.events.Publish(LifecycleEvent{
+			Type:      ImagePull,
+			Service:   service,
+			ID:        msg.ID,
+			Message:   msg.Status,
+			Progress:  progress,
+			Operation: app.queue.currentID(),
+		})
+	}
+}
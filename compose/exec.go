@@ -0,0 +1,82 @@
+package compose
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/Pungyeon/docker-gompose/utils"
+)
+
+var errIdentityUnsupported = errors.New("process identity is not supported on this platform")
+
+func (s *Service) GetEnvironment() ([]string, error) {
+	return s.environment(os.Environ())
+}
+
+// environment appends the env_file and environment entries of the service to
+// the given base environment, so that they take precedence over it.
+func (s *Service) environment(env []string) ([]string, error) {
+	var errs []error
+	for _, path := range s.EnvFile {
+		vars, err := parseEnvFile(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		env = append(env, vars...)
+	}
+	return append(env, s.Env...), utils.CombineErrors(errs...)
+}
+
+func parseEnvFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open env_file: %v", err)
+	}
+	defer file.Close()
+
+	var env []string
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		if !strings.Contains(entry, "=") {
+			return nil, fmt.Errorf("invalid entry in env_file %s (line %d): %v", path, line, entry)
+		}
+		env = append(env, entry)
+	}
+	return env, scanner.Err()
+}
+
+func newExecCommand(service Service) (*exec.Cmd, error) {
+	cmds := strings.Fields(service.Command)
+	if len(cmds) == 0 {
+		return nil, fmt.Errorf("no command specified for EXEC service")
+	}
+	return newServiceCommand(service, cmds)
+}
+
+// newServiceCommand creates a command running in the environment, working
+// directory and as the user of the service.
+func newServiceCommand(service Service, cmds []string) (*exec.Cmd, error) {
+	cmd := exec.Command(cmds[0], cmds[1:]...)
+	cmd.Env = os.Environ()
+	cmd.Dir = service.WorkingDir
+
+	var errs []error
+	if service.User != "" {
+		// the user is set first, so that the HOME and USER of the service
+		// environment take precedence over those of the user
+		errs = append(errs, setCommandUser(cmd, service.User))
+	}
+	env, err := service.environment(cmd.Env)
+	cmd.Env = env
+	errs = append(errs, err)
+	return cmd, utils.CombineErrors(errs...)
+}
@@ -0,0 +1,452 @@
+//go:build linux
+// +build linux
+
+package compose
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/Pungyeon/docker-gompose/utils"
+	"github.com/docker/go-units"
+	"golang.org/x/sys/unix"
+)
+
+const cgroupRoot = "/sys/fs/cgroup"
+
+var rlimitResources = map[string]int{
+	"cpu":        unix.RLIMIT_CPU,
+	"fsize":      unix.RLIMIT_FSIZE,
+	"data":       unix.RLIMIT_DATA,
+	"stack":      unix.RLIMIT_STACK,
+	"core":       unix.RLIMIT_CORE,
+	"rss":        unix.RLIMIT_RSS,
+	"nproc":      unix.RLIMIT_NPROC,
+	"nofile":     unix.RLIMIT_NOFILE,
+	"memlock":    unix.RLIMIT_MEMLOCK,
+	"as":         unix.RLIMIT_AS,
+	"locks":      unix.RLIMIT_LOCKS,
+	"sigpending": unix.RLIMIT_SIGPENDING,
+	"msgqueue":   unix.RLIMIT_MSGQUEUE,
+	"nice":       unix.RLIMIT_NICE,
+	"rtprio":     unix.RLIMIT_RTPRIO,
+}
+
+func setCommandUser(cmd *exec.Cmd, spec string) error {
+	parts := strings.SplitN(spec, ":", 2)
+	u, err := lookupUser(parts[0])
+	if err != nil {
+		return err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid uid for user %s: %v", spec, err)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid gid for user %s: %v", spec, err)
+	}
+	if len(parts) == 2 {
+		gid, err = lookupGroup(parts[1])
+		if err != nil {
+			return err
+		}
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{
+		Uid: uint32(uid),
+		Gid: uint32(gid),
+	}
+	if u.HomeDir != "" {
+		cmd.Env = append(cmd.Env, "HOME="+u.HomeDir, "USER="+u.Username)
+	}
+	return nil
+}
+
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		u, err := user.LookupId(name)
+		if err != nil {
+			// numeric ids don't need to exist in /etc/passwd
+			return &user.User{Uid: name, Gid: name}, nil
+		}
+		return u, nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("could not find user %s: %v", name, err)
+	}
+	return u, nil
+}
+
+func lookupGroup(name string) (uint64, error) {
+	if gid, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return gid, nil
+	}
+	group, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, fmt.Errorf("could not find group %s: %v", name, err)
+	}
+	return strconv.ParseUint(group.Gid, 10, 32)
+}
+
+// startProcess starts the command with the rlimits, niceness and cgroup of the
+// service already in place, so that no process it forks escapes them. The
+// command is cloned directly into its cgroup, and is traced until it has
+// executed, which lets the rlimits and niceness be set before it runs its first
+// instruction. Kernels older than 5.7 can't clone into a cgroup, so the traced
+// command is moved into it instead. When the command can't be traced, the
+// limits are only applied once it has started. The returned function removes
+// the cgroup once the process has exited. When the process cannot be limited,
+// it is killed and reaped.
+func startProcess(project, name string, cmd *exec.Cmd, service Service) (func(), error) {
+	release := func() {}
+	join := ""
+	if service.MemLimit != "" || service.CPUs != 0 {
+		group, err := createCgroup(project, name, service)
+		if err != nil {
+			return release, err
+		}
+		if group != nil {
+			defer group.Close()
+			path := group.Name()
+			release = func() { removeCgroup(path) }
+			if cloneIntoCgroupSupported() {
+				if cmd.SysProcAttr == nil {
+					cmd.SysProcAttr = &syscall.SysProcAttr{}
+				}
+				cmd.SysProcAttr.UseCgroupFD = true
+				cmd.SysProcAttr.CgroupFD = int(group.Fd())
+			} else {
+				join = path
+			}
+		}
+	}
+	if len(service.Ulimits) == 0 && service.Nice == 0 && join == "" {
+		if err := cmd.Start(); err != nil {
+			release()
+			return release, err
+		}
+		return release, nil
+	}
+
+	traced := canTraceProcesses()
+	if traced {
+		// a tracee can only be controlled from the thread which started it
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		cmd.SysProcAttr.Ptrace = true
+	} else {
+		log.Printf("processes cannot be traced, the limits of %s are applied once it has started\n", name)
+	}
+	if err := cmd.Start(); err != nil {
+		release()
+		return release, err
+	}
+	pid := cmd.Process.Pid
+	var err error
+	if traced {
+		// the command stops with a SIGTRAP once it has executed
+		var status syscall.WaitStatus
+		_, err = syscall.Wait4(pid, &status, 0, nil)
+	}
+	if err == nil && join != "" {
+		err = writeCgroupFile(join, "cgroup.procs", strconv.Itoa(pid))
+	}
+	if err == nil {
+		err = applyProcessLimits(pid, service)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		if traced {
+			syscall.PtraceDetach(pid)
+		}
+		cmd.Wait()
+		release()
+		return release, fmt.Errorf("could not apply limits to process %s: %v", name, err)
+	}
+	if traced {
+		return release, syscall.PtraceDetach(pid)
+	}
+	return release, nil
+}
+
+// cloneIntoCgroupSupported reports whether the kernel can start a process
+// directly into a cgroup, which was added in Linux 5.7.
+func cloneIntoCgroupSupported() bool {
+	var uname unix.Utsname
+	if err := unix.Uname(&uname); err != nil {
+		return false
+	}
+	return kernelAtLeast(unix.ByteSliceToString(uname.Release[:]), 5, 7)
+}
+
+// kernelAtLeast reports whether the kernel release, e.g. 5.10.0-8-amd64, is
+// at least the given version.
+func kernelAtLeast(release string, major, minor int) bool {
+	parts := strings.SplitN(release, ".", 3)
+	if len(parts) < 2 {
+		return false
+	}
+	gotMajor, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return false
+	}
+	// the minor version may be followed by a suffix, e.g. 5.7-rc1
+	if end := strings.IndexFunc(parts[1], func(r rune) bool { return r < '0' || r > '9' }); end != -1 {
+		parts[1] = parts[1][:end]
+	}
+	gotMinor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return false
+	}
+	return gotMajor > major || gotMajor == major && gotMinor >= minor
+}
+
+// canTraceProcesses reports whether the server may trace the processes it
+// starts. Yama forbids it with ptrace_scope 3, and a process started by a
+// traced server may already be traced by its tracer.
+func canTraceProcesses() bool {
+	if scope, err := ioutil.ReadFile("/proc/sys/kernel/yama/ptrace_scope"); err == nil && strings.TrimSpace(string(scope)) == "3" {
+		return false
+	}
+	status, err := ioutil.ReadFile("/proc/self/status")
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(status), "\n") {
+		if strings.HasPrefix(line, "TracerPid:") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "TracerPid:")) == "0"
+		}
+	}
+	return true
+}
+
+// applyProcessLimits sets the rlimits and niceness of a process which hasn't
+// started running yet.
+func applyProcessLimits(pid int, service Service) error {
+	var errs []error
+	for resource, limit := range service.Ulimits {
+		errs = append(errs, setRlimit(pid, resource, limit))
+	}
+	if service.Nice != 0 {
+		if err := unix.Setpriority(unix.PRIO_PROCESS, pid, service.Nice); err != nil {
+			errs = append(errs, fmt.Errorf("could not set nice value: %v", err))
+		}
+	}
+	return utils.CombineErrors(errs...)
+}
+
+func setRlimit(pid int, resource string, limit Ulimit) error {
+	id, ok := rlimitResources[resource]
+	if !ok {
+		return fmt.Errorf("unsupported ulimit: %v", resource)
+	}
+	rlimit := unix.Rlimit{Cur: uint64(limit.Soft), Max: uint64(limit.Hard)}
+	if err := unix.Prlimit(pid, id, &rlimit, nil); err != nil {
+		return fmt.Errorf("could not set ulimit %s: %v", resource, err)
+	}
+	return nil
+}
+
+// createCgroup creates the cgroup of the service under the gompose slice of
+// the project, within the cgroup of the server, and returns it opened, ready
+// to start a process into. This is only supported on hosts using the unified
+// (v2) hierarchy with the memory and cpu controllers delegated to the server,
+// e.g. by running it as a systemd unit with Delegate=yes; otherwise mem_limit
+// and cpus are ignored, and no cgroup is returned.
+func createCgroup(project, name string, service Service) (*os.File, error) {
+	var memory int64
+	if service.MemLimit != "" {
+		var err error
+		if memory, err = units.RAMInBytes(service.MemLimit); err != nil {
+			return nil, fmt.Errorf("could not parse mem_limit: %v", err)
+		}
+	}
+	if !cgroupName.MatchString(project) || !cgroupName.MatchString(name) {
+		return nil, fmt.Errorf("invalid cgroup name for service %s of project %s", name, project)
+	}
+	parent, err := cgroupParent()
+	if err != nil {
+		log.Printf("cgroup v2 is not available, ignoring mem_limit and cpus for %s: %v\n", name, err)
+		return nil, nil
+	}
+	group, err := setupCgroup(parent, project, name, memory, service.CPUs)
+	if err != nil {
+		log.Printf("could not create the cgroup of %s, ignoring mem_limit and cpus: %v\n", name, err)
+		if group != "" {
+			removeCgroup(group)
+		}
+		return nil, nil
+	}
+	return os.Open(group)
+}
+
+// cgroupName matches the names that are safe to use as a cgroup directory.
+var cgroupName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// serverCgroup is the leaf cgroup that the server moves itself into, as the
+// controllers of a cgroup can only be delegated to its children while it has
+// no processes of its own.
+const serverCgroup = "gompose-server"
+
+// cgroupParent returns the directory of the cgroup of the server, which the
+// cgroups of the services are created in.
+func cgroupParent() (string, error) {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return "", err
+	}
+	data, err := ioutil.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	path, err := parseCgroupPath(string(data))
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(cgroupRoot, path)
+	if filepath.Base(dir) == serverCgroup {
+		dir = filepath.Dir(dir)
+	}
+	return dir, nil
+}
+
+// parseCgroupPath returns the path of the cgroup v2 entry of /proc/self/cgroup.
+func parseCgroupPath(data string) (string, error) {
+	for _, line := range strings.Split(data, "\n") {
+		if strings.HasPrefix(line, "0::") {
+			return filepath.Clean("/" + strings.TrimPrefix(line, "0::")), nil
+		}
+	}
+	return "", fmt.Errorf("the server is not in a cgroup v2 hierarchy")
+}
+
+func setupCgroup(parent, project, name string, memory int64, cpus float64) (string, error) {
+	if err := enableControllers(parent); err != nil {
+		return "", err
+	}
+	for _, dir := range []string{"gompose", project} {
+		parent = filepath.Join(parent, dir)
+		if err := os.MkdirAll(parent, 0755); err != nil {
+			return "", err
+		}
+		if err := writeCgroupFile(parent, "cgroup.subtree_control", "+memory +cpu"); err != nil {
+			return "", err
+		}
+	}
+	group := filepath.Join(parent, name)
+	if err := os.MkdirAll(group, 0755); err != nil {
+		return "", err
+	}
+	if memory != 0 {
+		if err := writeCgroupFile(group, "memory.max", strconv.FormatInt(memory, 10)); err != nil {
+			return group, err
+		}
+	}
+	if cpus > 0 {
+		period := 100000
+		quota := int(cpus * float64(period))
+		if err := writeCgroupFile(group, "cpu.max", fmt.Sprintf("%d %d", quota, period)); err != nil {
+			return group, err
+		}
+	}
+	return group, nil
+}
+
+// enableControllers delegates the memory and cpu controllers of the cgroup of
+// the server to its children, first moving the server into a leaf cgroup when
+// it is busy.
+func enableControllers(parent string) error {
+	controllers := []byte("+memory +cpu")
+	err := ioutil.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), controllers, 0644)
+	if pathErr, ok := err.(*os.PathError); !ok || pathErr.Err != syscall.EBUSY {
+		return err
+	}
+	leaf := filepath.Join(parent, serverCgroup)
+	if err := os.MkdirAll(leaf, 0755); err != nil {
+		return err
+	}
+	if err := writeCgroupFile(leaf, "cgroup.procs", strconv.Itoa(os.Getpid())); err != nil {
+		return err
+	}
+	return writeCgroupFile(parent, "cgroup.subtree_control", string(controllers))
+}
+
+func writeCgroupFile(group, file, value string) error {
+	if err := ioutil.WriteFile(filepath.Join(group, file), []byte(value), 0644); err != nil {
+		return fmt.Errorf("could not write %s for cgroup %s: %v", file, group, err)
+	}
+	return nil
+}
+
+func removeCgroup(group string) {
+	if err := os.Remove(group); err != nil && !os.IsNotExist(err) {
+		log.Printf("could not remove cgroup %s: %v\n", group, err)
+	}
+}
+
+// removeProcessCgroup removes the cgroup of a stopped service, which may not
+// have been started by this gompose process.
+func removeProcessCgroup(project, name string) {
+	if !cgroupName.MatchString(project) || !cgroupName.MatchString(name) {
+		return
+	}
+	parent, err := cgroupParent()
+	if err != nil {
+		return
+	}
+	removeCgroup(filepath.Join(parent, "gompose", project, name))
+}
+
+// processIdentity returns the start time (in clock ticks since boot) and the
+// command line of the given pid, which together identify a process even if
+// its pid has since been reused.
+func processIdentity(pid int) (uint64, string, error) {
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, "", err
+	}
+	// the command name may contain spaces, so fields are counted from the
+	// closing parenthesis, where the process state (field 3) begins.
+	end := strings.LastIndex(string(stat), ")")
+	if end == -1 {
+		return 0, "", fmt.Errorf("could not parse stat of process %d", pid)
+	}
+	fields := strings.Fields(string(stat[end+1:]))
+	if len(fields) < 20 {
+		return 0, "", fmt.Errorf("could not parse stat of process %d", pid)
+	}
+	startTime, err := strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("could not parse start time of process %d: %v", pid, err)
+	}
+	cmdline, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return 0, "", err
+	}
+	return startTime, strings.TrimRight(strings.Replace(string(cmdline), "\x00", " ", -1), " "), nil
+}
+
+// processEnviron returns the environment the process was started with.
+func processEnviron(pid int) ([]string, error) {
+	environ, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/environ", pid))
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimRight(string(environ), "\x00"), "\x00"), nil
+}
@@ -0,0 +1,94 @@
+//go:build linux
+// +build linux
+
+package compose
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestKernelAtLeast(t *testing.T) {
+	tests := []struct {
+		release string
+		want    bool
+	}{
+		{"5.7.0", true},
+		{"5.10.0-8-amd64", true},
+		{"6.1.0", true},
+		{"5.6.19", false},
+		{"4.19.0-17-amd64", false},
+		{"5.7-rc1", true},
+		{"5", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if got := kernelAtLeast(test.release, 5, 7); got != test.want {
+			t.Errorf("kernelAtLeast(%q, 5, 7) = %v, expected %v", test.release, got, test.want)
+		}
+	}
+}
+
+func TestParseCgroupPath(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+		err  bool
+	}{
+		{data: "0::/user.slice/user-1000.slice/user@1000.service/app.slice/gompose.service\n", want: "/user.slice/user-1000.slice/user@1000.service/app.slice/gompose.service"},
+		{data: "0::/\n", want: "/"},
+		{data: "12:memory:/docker/abc\n0::/system.slice/gompose.service\n", want: "/system.slice/gompose.service"},
+		{data: "0::/../../escape\n", want: "/escape"},
+		{data: "12:memory:/docker/abc\n", err: true},
+	}
+	for _, test := range tests {
+		got, err := parseCgroupPath(test.data)
+		if test.err {
+			if err == nil {
+				t.Errorf("parseCgroupPath(%q) = %q, expected an error", test.data, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("parseCgroupPath(%q) = %q, %v, expected %q", test.data, got, err, test.want)
+		}
+	}
+}
+
+func TestCgroupName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"web", true},
+		{"my_app-2.worker", true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"../../escape", false},
+		{"a/b", false},
+		{"-web", false},
+	}
+	for _, test := range tests {
+		if got := cgroupName.MatchString(test.name); got != test.valid {
+			t.Errorf("cgroupName.MatchString(%q) = %v, expected %v", test.name, got, test.valid)
+		}
+	}
+}
+
+func TestProcessEnviron(t *testing.T) {
+	cmd := exec.Command("sleep", "10")
+	cmd.Env = []string{"A=1", "TOKEN=hunter22"}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+	env, err := processEnviron(cmd.Process.Pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values := envValues(env, []string{"TOKEN"}); len(values) != 1 || values[0] != "hunter22" {
+		t.Errorf("environment = %q, expected TOKEN=hunter22", env)
+	}
+}
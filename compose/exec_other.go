@@ -0,0 +1,31 @@
+//go:build !linux
+// +build !linux
+
+package compose
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+func setCommandUser(cmd *exec.Cmd, spec string) error {
+	return fmt.Errorf("user is not supported for EXEC services on this platform")
+}
+
+func startProcess(project, name string, cmd *exec.Cmd, service Service) (func(), error) {
+	release := func() {}
+	if len(service.Ulimits) != 0 || service.Nice != 0 || service.MemLimit != "" || service.CPUs != 0 {
+		return release, fmt.Errorf("resource limits are not supported for EXEC services on this platform")
+	}
+	return release, cmd.Start()
+}
+
+func removeProcessCgroup(project, name string) {}
+
+func processIdentity(pid int) (uint64, string, error) {
+	return 0, "", errIdentityUnsupported
+}
+
+func processEnviron(pid int) ([]string, error) {
+	return nil, errIdentityUnsupported
+}
@@ -0,0 +1,82 @@
+//go:build !windows
+// +build !windows
+
+package compose
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// commandOwner returns the uid and gid the command runs as, or -1 when it
+// runs as the current user.
+func commandOwner(cmd *exec.Cmd) (int, int) {
+	if cmd.SysProcAttr == nil || cmd.SysProcAttr.Credential == nil {
+		return -1, -1
+	}
+	return int(cmd.SysProcAttr.Credential.Uid), int(cmd.SysProcAttr.Credential.Gid)
+}
+
+func processGroupAlive(pgid int) bool {
+	if pgid <= 0 {
+		return false
+	}
+	return syscall.Kill(-pgid, 0) == nil
+}
+
+func signalProcessGroup(pgid int, signal string) error {
+	if pgid <= 0 {
+		return fmt.Errorf("invalid process group: %d", pgid)
+	}
+	sig, err := parseSignal(signal)
+	if err != nil {
+		return err
+	}
+	if err := syscall.Kill(-pgid, sig); err != nil && err != syscall.ESRCH {
+		return fmt.Errorf("could not signal process group %d: %v", pgid, err)
+	}
+	return nil
+}
+
+func stopProcessGroup(proc Process) error {
+	pgid := proc.PGID
+	if pgid == 0 {
+		pgid = proc.PID
+	}
+	if !processGroupAlive(pgid) {
+		return nil
+	}
+	signal := proc.StopSignal
+	if signal == "" {
+		signal = "SIGTERM"
+	}
+	return signalProcessGroup(pgid, signal)
+}
+
+// parseSignal parses a signal given by number, or by name with or without
+// the SIG prefix, accepting every signal of the platform as docker does.
+func parseSignal(signal string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(signal); err == nil {
+		return syscall.Signal(n), nil
+	}
+	name := strings.ToUpper(signal)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	if sig := unix.SignalNum(name); sig != 0 {
+		return sig, nil
+	}
+	return 0, fmt.Errorf("unknown signal: %v", signal)
+}
@@ -0,0 +1,39 @@
+//go:build !windows
+// +build !windows
+
+package compose
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestParseSignal(t *testing.T) {
+	tests := []struct {
+		signal string
+		want   syscall.Signal
+		err    bool
+	}{
+		{signal: "SIGTERM", want: syscall.SIGTERM},
+		{signal: "TERM", want: syscall.SIGTERM},
+		{signal: "sigquit", want: syscall.SIGQUIT},
+		{signal: "SIGWINCH", want: syscall.SIGWINCH},
+		{signal: "SIGPIPE", want: syscall.SIGPIPE},
+		{signal: "USR2", want: syscall.SIGUSR2},
+		{signal: "9", want: syscall.SIGKILL},
+		{signal: "SIGNOPE", err: true},
+		{signal: "", err: true},
+	}
+	for _, test := range tests {
+		sig, err := parseSignal(test.signal)
+		if test.err {
+			if err == nil {
+				t.Errorf("parseSignal(%q) = %v, expected an error", test.signal, sig)
+			}
+			continue
+		}
+		if err != nil || sig != test.want {
+			t.Errorf("parseSignal(%q) = %v, %v, expected %v", test.signal, sig, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package compose
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func commandOwner(cmd *exec.Cmd) (int, int) {
+	return -1, -1
+}
+
+func processGroupAlive(pgid int) bool {
+	if pgid <= 0 {
+		return false
+	}
+	proc, err := os.FindProcess(pgid)
+	return err == nil && proc.Signal(syscall.Signal(0)) == nil
+}
+
+func signalProcessGroup(pgid int, signal string) error {
+	proc, err := os.FindProcess(pgid)
+	if err != nil {
+		return err
+	}
+	return proc.Kill()
+}
+
+func stopProcessGroup(proc Process) error {
+	if !processGroupAlive(proc.PID) {
+		return nil
+	}
+	return signalProcessGroup(proc.PID, proc.StopSignal)
+}
@@ -0,0 +1,13 @@
+package compose
+
+import "os"
+
+// advisory locking is not supported on windows, where the lock file is only
+// protected by the single server per directory convention.
+func lockFile(file *os.File) error {
+	return nil
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,134 @@
+package compose
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	defaultRegistry  = "docker.io"
+	defaultNamespace = "library"
+	defaultTag       = "latest"
+)
+
+type ImageReference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+func ParseImageReference(image string) (ImageReference, error) {
+	if image == "" {
+		return ImageReference{}, fmt.Errorf("could not parse empty image reference")
+	}
+	var ref ImageReference
+	name := image
+	if i := strings.Index(name, "@"); i != -1 {
+		ref.Digest = name[i+1:]
+		name = name[:i]
+		if !isValidDigest(ref.Digest) {
+			return ImageReference{}, fmt.Errorf("invalid digest in image reference: %v", image)
+		}
+	}
+	if i := strings.LastIndex(name, ":"); i != -1 && !strings.Contains(name[i+1:], "/") {
+		ref.Tag = name[i+1:]
+		name = name[:i]
+		if ref.Tag == "" {
+			return ImageReference{}, fmt.Errorf("invalid tag in image reference: %v", image)
+		}
+	}
+	components := strings.Split(name, "/")
+	if len(components) > 1 && isRegistry(components[0]) {
+		ref.Registry = components[0]
+		components = components[1:]
+	}
+	for _, component := range components {
+		if component == "" || component != strings.ToLower(component) {
+			return ImageReference{}, fmt.Errorf("invalid repository in image reference: %v", image)
+		}
+	}
+	ref.Repository = strings.Join(components, "/")
+	return ref, nil
+}
+
+func (ref ImageReference) Normalized() ImageReference {
+	if ref.Registry == "" || ref.Registry == "index.docker.io" {
+		ref.Registry = defaultRegistry
+	}
+	if ref.Registry == defaultRegistry && !strings.Contains(ref.Repository, "/") {
+		ref.Repository = defaultNamespace + "/" + ref.Repository
+	}
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = defaultTag
+	}
+	return ref
+}
+
+func (ref ImageReference) Name() string {
+	if ref.Registry == "" {
+		return ref.Repository
+	}
+	return ref.Registry + "/" + ref.Repository
+}
+
+func (ref ImageReference) WithDigest(digest string) ImageReference {
+	ref.Digest = digest
+	return ref
+}
+
+func (ref ImageReference) String() string {
+	str := ref.Name()
+	if ref.Tag != "" {
+		str += ":" + ref.Tag
+	}
+	if ref.Digest != "" {
+		str += "@" + ref.Digest
+	}
+	return str
+}
+
+func isRegistry(component string) bool {
+	return strings.ContainsAny(component, ".:") || component == "localhost"
+}
+
+// digestLengths are the lengths of the hex encoded digests of the supported
+// algorithms.
+var digestLengths = map[string]int{
+	"sha256": 64,
+	"sha384": 96,
+	"sha512": 128,
+}
+
+func isValidDigest(digest string) bool {
+	parts := strings.SplitN(digest, ":", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	length, ok := digestLengths[parts[0]]
+	if !ok || len(parts[1]) != length {
+		return false
+	}
+	for _, c := range parts[1] {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// digestFromRepoDigests returns the digest of the first repo digest that
+// matches the repository of ref, e.g. postgres@sha256:... for postgres.
+func digestFromRepoDigests(ref ImageReference, repoDigests []string) string {
+	name := ref.Normalized().Name()
+	for _, repoDigest := range repoDigests {
+		parsed, err := ParseImageReference(repoDigest)
+		if err != nil || parsed.Digest == "" {
+			continue
+		}
+		if parsed.Normalized().Name() == name {
+			return parsed.Digest
+		}
+	}
+	return ""
+}
@@ -0,0 +1,72 @@
+package compose
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseImageReference(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+	tests := []struct {
+		image string
+		want  ImageReference
+		err   bool
+	}{
+		{image: "postgres", want: ImageReference{Repository: "postgres"}},
+		{image: "postgres:13", want: ImageReference{Repository: "postgres", Tag: "13"}},
+		{image: "library/postgres:13", want: ImageReference{Repository: "library/postgres", Tag: "13"}},
+		{image: "localhost/app", want: ImageReference{Registry: "localhost", Repository: "app"}},
+		{image: "localhost:5000/app:1.0", want: ImageReference{Registry: "localhost:5000", Repository: "app", Tag: "1.0"}},
+		{image: "ghcr.io/org/app/web:v2", want: ImageReference{Registry: "ghcr.io", Repository: "org/app/web", Tag: "v2"}},
+		{image: "postgres@" + digest, want: ImageReference{Repository: "postgres", Digest: digest}},
+		{image: "postgres:13@" + digest, want: ImageReference{Repository: "postgres", Tag: "13", Digest: digest}},
+		{image: "", err: true},
+		{image: "postgres:", err: true},
+		{image: "Postgres", err: true},
+		{image: "org//app", err: true},
+		{image: "postgres@sha256:abc", err: true},
+		{image: "postgres@md5:" + strings.Repeat("a", 32), err: true},
+		{image: "postgres@sha256:" + strings.Repeat("g", 64), err: true},
+		{image: "postgres@sha512:" + strings.Repeat("a", 64), err: true},
+	}
+	for _, test := range tests {
+		ref, err := ParseImageReference(test.image)
+		if test.err {
+			if err == nil {
+				t.Errorf("ParseImageReference(%q) = %+v, expected an error", test.image, ref)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseImageReference(%q) returned an error: %v", test.image, err)
+			continue
+		}
+		if ref != test.want {
+			t.Errorf("ParseImageReference(%q) = %+v, expected %+v", test.image, ref, test.want)
+		}
+		if ref.String() != test.image {
+			t.Errorf("ParseImageReference(%q).String() = %q", test.image, ref.String())
+		}
+	}
+}
+
+func TestImageReferenceNormalized(t *testing.T) {
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{image: "postgres", want: "docker.io/library/postgres:latest"},
+		{image: "postgres:13", want: "docker.io/library/postgres:13"},
+		{image: "index.docker.io/org/app", want: "docker.io/org/app:latest"},
+		{image: "ghcr.io/app:v2", want: "ghcr.io/app:v2"},
+	}
+	for _, test := range tests {
+		ref, err := ParseImageReference(test.image)
+		if err != nil {
+			t.Fatalf("ParseImageReference(%q) returned an error: %v", test.image, err)
+		}
+		if got := ref.Normalized().String(); got != test.want {
+			t.Errorf("%q normalized to %q, expected %q", test.image, got, test.want)
+		}
+	}
+}
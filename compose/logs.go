@@ -0,0 +1,117 @@
+package compose
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+const logDir = ".gompose/logs"
+
+// openProcessLog opens the file that the output of an EXEC service is
+// appended to, as these have no log driver of their own.
+func (app *App) openProcessLog(name string) (*os.File, error) {
+	dir := filepath.Join(logDir, app.project)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(filepath.Join(dir, name+".log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+}
+
+// Logs writes the output of the given service to the writer, until the
+// context is cancelled if the logs are followed. The secrets and sensitive
+// environment values of the started services are redacted, unless
+// ShowSecrets is set.
+func (app *App) Logs(ctx context.Context, service string, options LogOptions, writer io.Writer) error {
+	if !options.ShowSecrets {
+		redacting := app.redactor.Writer(writer)
+		defer redacting.Close()
+		writer = redacting
+	}
+	if proc, ok := app.container(service); ok {
+		reader, err := app.cli.ContainerLogs(ctx, proc.ID, types.ContainerLogsOptions{
+			ShowStdout: true,
+			ShowStderr: true,
+			Follow:     options.Follow,
+			Tail:       options.Tail,
+		})
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		_, err = stdcopy.StdCopy(writer, writer, reader)
+		return err
+	}
+	if _, ok := app.process(service); ok {
+		return app.processLogs(ctx, service, options, writer)
+	}
+	return fmt.Errorf("no such service: %s", service)
+}
+
+func (app *App) processLogs(ctx context.Context, service string, options LogOptions, writer io.Writer) error {
+	file, err := os.Open(filepath.Join(logDir, app.project, service+".log"))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := writeTail(file, options.Tail, writer); err != nil {
+		return err
+	}
+
+	for {
+		if _, err := io.Copy(writer, file); err != nil {
+			return err
+		}
+		if !options.Follow {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Millisecond * 500):
+		}
+	}
+}
+
+// writeTail writes the last lines of the file, leaving the file positioned at
+// its end. All lines are left to be copied if tail is empty or "all".
+func writeTail(file *os.File, tail string, writer io.Writer) error {
+	if tail == "" || tail == "all" {
+		return nil
+	}
+	lines, err := strconv.Atoi(tail)
+	if err != nil {
+		return fmt.Errorf("invalid tail: %v", tail)
+	}
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(lastLines(data, lines))
+	return err
+}
+
+func lastLines(data []byte, n int) []byte {
+	if n <= 0 {
+		return nil
+	}
+	// skip the trailing newline terminating the last line
+	count := 0
+	for i := len(data) - 2; i >= 0; i-- {
+		if data[i] == '\n' {
+			count++
+			if count == n {
+				return data[i+1:]
+			}
+		}
+	}
+	return data
+}
@@ -0,0 +1,27 @@
+package compose
+
+import "testing"
+
+func TestLastLines(t *testing.T) {
+	tests := []struct {
+		data string
+		n    int
+		want string
+	}{
+		{data: "a\nb\nc\n", n: 2, want: "b\nc\n"},
+		{data: "a\nb\nc\n", n: 1, want: "c\n"},
+		{data: "a\nb\nc\n", n: 3, want: "a\nb\nc\n"},
+		{data: "a\nb\nc\n", n: 10, want: "a\nb\nc\n"},
+		{data: "a\nb\nc", n: 1, want: "c"},
+		{data: "a\nb\nc", n: 2, want: "b\nc"},
+		{data: "a\n\nc\n", n: 2, want: "\nc\n"},
+		{data: "a\nb\n", n: 0, want: ""},
+		{data: "a\nb\n", n: -1, want: ""},
+		{data: "", n: 5, want: ""},
+	}
+	for _, test := range tests {
+		if got := string(lastLines([]byte(test.data), test.n)); got != test.want {
+			t.Errorf("lastLines(%q, %d) = %q, expected %q", test.data, test.n, got, test.want)
+		}
+	}
+}
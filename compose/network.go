@@ -0,0 +1,92 @@
+package compose
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+)
+
+// NetworkConfig is a network declared at the top level of the definition.
+// Only external networks, which are managed outside of gompose, can be
+// declared: services join them in addition to the network of the project.
+type NetworkConfig struct {
+	External bool   `yaml:"external"`
+	Name     string `yaml:"name"`
+}
+
+// GetName returns the name of the docker network, which defaults to the key
+// of the network in the definition.
+func (config NetworkConfig) GetName(key string) string {
+	if config.Name == "" {
+		return key
+	}
+	return config.Name
+}
+
+// registerExternalNetworks verifies that the external networks used by the
+// services exist, and records their IDs.
+func (app *App) registerExternalNetworks(definition Definition) error {
+	for _, service := range definition.Services {
+		for _, key := range service.Networks {
+			name := definition.Networks[key].GetName(key)
+			inspect, err := app.cli.NetworkInspect(context.Background(), name, types.NetworkInspectOptions{})
+			if err != nil {
+				return fmt.Errorf("external network %s not found: %v", name, err)
+			}
+			app.mu.Lock()
+			app.ExternalNetworks[key] = inspect.ID
+			app.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+// registerExternalVolume verifies that the external volume exists, and records
+// its name to mount it by.
+func (app *App) registerExternalVolume(key, name string) error {
+	if _, err := app.cli.VolumeInspect(context.Background(), name); err != nil {
+		return fmt.Errorf("external volume %s not found: %v", name, err)
+	}
+	app.mu.Lock()
+	app.ExternalVolumes[key] = name
+	app.mu.Unlock()
+	return nil
+}
+
+// volumeSources returns the docker volumes to mount for each volume source,
+// both those created by gompose and the external volumes.
+func (app *App) volumeSources() map[string]string {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+	sources := make(map[string]string, len(app.Volumes)+len(app.ExternalVolumes))
+	for key, id := range app.Volumes {
+		sources[key] = id
+	}
+	for key, name := range app.ExternalVolumes {
+		sources[key] = name
+	}
+	return sources
+}
+
+// connectExternalNetworks connects the container to the external networks of
+// its service, under the given alias.
+func (app *App) connectExternalNetworks(ctx context.Context, id, alias string, networks []string) error {
+	for _, key := range networks {
+		app.mu.RLock()
+		networkID, ok := app.ExternalNetworks[key]
+		app.mu.RUnlock()
+		if !ok {
+			return fmt.Errorf("external network %s has not been registered", key)
+		}
+		settings := &network.EndpointSettings{}
+		if alias != "" {
+			settings.Aliases = []string{alias}
+		}
+		if err := app.cli.NetworkConnect(ctx, networkID, id, settings); err != nil {
+			return fmt.Errorf("[container: %s, network: %s] network connect returned with status: %v", id, key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package compose
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	DefaultProject = "gompose"
+
+	defaultStopTimeout = time.Second * 15
+)
+
+// RunOptions are the command line options that modify how a command is
+// executed.
+type RunOptions struct {
+	Format string
+	// Timeout overrides the stop grace period of the services when set,
+	// zero stopping them immediately.
+	Timeout *time.Duration
+
+	// Profiles select the services started by start and restart.
+	Profiles []string
+
+	// RemoveVolumes, RemoveOrphans and RemoveImages only apply to rm.
+	RemoveVolumes bool
+	RemoveOrphans bool
+	RemoveImages  string
+
+	// Operation is the id under which the events of the command are
+	// published, a new id is used when it is zero.
+	Operation uint64
+}
+
+func (options RunOptions) Validate() error {
+	if options.Timeout != nil && *options.Timeout < 0 {
+		return fmt.Errorf("invalid timeout: %v", *options.Timeout)
+	}
+	switch options.RemoveImages {
+	case "", "local", "all":
+		return nil
+	default:
+		return fmt.Errorf("invalid value for rmi, expected local or all: %v", options.RemoveImages)
+	}
+}
+
+// stopTimeout returns how long the process is given to exit before it is
+// killed: the timeout given on the command line, or else the grace period of
+// the service.
+func (options RunOptions) stopTimeout(proc Process) time.Duration {
+	if options.Timeout != nil {
+		return *options.Timeout
+	}
+	if proc.StopGracePeriod > 0 {
+		return proc.StopGracePeriod
+	}
+	return defaultStopTimeout
+}
+
+type LogOptions struct {
+	Follow bool
+	Tail   string
+	// ShowSecrets disables the redaction of sensitive values.
+	ShowSecrets bool
+}
+
+// ExecOptions configure a command run in a service by exec and run.
+type ExecOptions struct {
+	Cmd        []string
+	Tty        bool
+	Stdin      bool
+	Env        []string
+	User       string
+	WorkingDir string
+
+	// Remove, Entrypoint and Volumes only apply to run.
+	Remove     bool
+	Entrypoint string
+	Volumes    []string
+}
+
+type TerminalSize struct {
+	Height uint
+	Width  uint
+}
+
+// Streams connect the command to the client. Stdin is only read when the
+// command is started with Stdin set.
+type Streams struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	Resize <-chan TerminalSize
+}
@@ -1,9 +1,71 @@
 package compose
 
-import "github.com/docker/docker/api/types/container"
+import (
+	"fmt"
+
+	"github.com/Pungyeon/docker-gompose/utils"
+	"github.com/docker/docker/api/types/container"
+)
 
 type Definition struct {
 	Services map[string]Service
+	Volumes  map[string]VolumeConfig
+	Networks map[string]NetworkConfig
+	Secrets  map[string]FileSource
+	Configs  map[string]FileSource
+
+	Redaction RedactionPolicy
+}
+
+// Validate reports every problem found in the definition, without contacting
+// the docker daemon.
+func (definition Definition) Validate() error {
+	var errs []error
+	for name, config := range definition.Networks {
+		if !config.External {
+			errs = append(errs, fmt.Errorf("network %s must be external, services are always connected to the network of the project", name))
+		}
+	}
+	if err := definition.Redaction.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	for name, source := range definition.Secrets {
+		if err := source.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("invalid secret %s: %v", name, err))
+		}
+	}
+	for name, source := range definition.Configs {
+		if err := source.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("invalid config %s: %v", name, err))
+		}
+	}
+	for name, service := range definition.Services {
+		exec := DriverFromString(service.Driver) == EXEC
+		if !exec && service.ReadOnly && len(service.Configs) != 0 {
+			// configs are copied into the container before it is started
+			errs = append(errs, fmt.Errorf("service %s: configs are not supported with read_only", name))
+		}
+		for _, err := range validateFileReferences("secret", service.Secrets, definition.Secrets, exec) {
+			errs = append(errs, fmt.Errorf("service %s: %v", name, err))
+		}
+		for _, err := range validateFileReferences("config", service.Configs, definition.Configs, exec) {
+			errs = append(errs, fmt.Errorf("service %s: %v", name, err))
+		}
+		for _, net := range service.Networks {
+			if _, ok := definition.Networks[net]; !ok {
+				errs = append(errs, fmt.Errorf("service %s uses undefined network %s", name, net))
+			}
+		}
+		for _, dep := range service.DependsOn {
+			if _, ok := definition.Services[dep]; !ok {
+				errs = append(errs, fmt.Errorf("service %s depends on undefined service %s", name, dep))
+			}
+		}
+		if err := service.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("invalid definition for service %s: %v", name, err))
+		}
+	}
+	return utils.CombineErrors(errs...)
 }
 
 type RestartPolicy struct {
@@ -11,6 +73,25 @@ type RestartPolicy struct {
 	MaximumRetries int `yaml:"max_attempts"`
 }
 
+func (policy RestartPolicy) Validate() error {
+	switch policy.Condition {
+	case "no", "always", "unless-stopped":
+		// max_attempts is accepted but ignored with always, as docker restarts
+		// those containers indefinitely
+		if policy.MaximumRetries != 0 && policy.Condition != "always" {
+			return fmt.Errorf("max_attempts is only supported with restart conditions on-failure and always (where it is ignored), got: %v", policy.Condition)
+		}
+		return nil
+	case "on-failure":
+		if policy.MaximumRetries < 0 {
+			return fmt.Errorf("invalid restart max_attempts: %d", policy.MaximumRetries)
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid restart condition: %v", policy.Condition)
+	}
+}
+
 func (policy RestartPolicy) ToDockerPolicy() container.RestartPolicy {
 	if policy.Condition == "always" {
 		return container.RestartPolicy{
@@ -0,0 +1,31 @@
+package compose
+
+import "testing"
+
+func TestRestartPolicyValidate(t *testing.T) {
+	tests := []struct {
+		policy RestartPolicy
+		valid  bool
+	}{
+		{policy: RestartPolicy{Condition: "no"}, valid: true},
+		{policy: RestartPolicy{Condition: "always"}, valid: true},
+		{policy: RestartPolicy{Condition: "unless-stopped"}, valid: true},
+		{policy: RestartPolicy{Condition: "on-failure"}, valid: true},
+		{policy: RestartPolicy{Condition: "on-failure", MaximumRetries: 3}, valid: true},
+		{policy: RestartPolicy{Condition: "always", MaximumRetries: 3}, valid: true},
+		{policy: RestartPolicy{Condition: "no", MaximumRetries: 3}, valid: false},
+		{policy: RestartPolicy{Condition: "unless-stopped", MaximumRetries: 3}, valid: false},
+		{policy: RestartPolicy{Condition: "on-failure", MaximumRetries: -1}, valid: false},
+		{policy: RestartPolicy{Condition: ""}, valid: false},
+		{policy: RestartPolicy{Condition: "sometimes"}, valid: false},
+	}
+	for _, test := range tests {
+		err := test.policy.Validate()
+		if test.valid && err != nil {
+			t.Errorf("%+v: unexpected error: %v", test.policy, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%+v: expected an error", test.policy)
+		}
+	}
+}
@@ -1,6 +1,11 @@
 package compose
 
-import "strings"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+)
 
 type Process struct {
 	ID string
@@ -9,9 +14,31 @@ type Process struct {
 	OnStop     string
 	PID        int
 	StopSignal string
+	Image      string
+	PGID       int
+	StartTime  uint64
+	// CmdlineHash is the hash of the command line of the process, which is
+	// not stored as is as it may contain sensitive values.
+	CmdlineHash string
+	// StopGracePeriod is how long the service is given to exit after being
+	// signalled, before it is killed.
+	StopGracePeriod time.Duration
+	DependsOn       []string
+	// FilesDir is the temporary directory holding the secrets and configs of
+	// an EXEC service, or the secrets mounted into a container.
+	FilesDir string
+	// SensitiveEnv are the names of the environment variables whose values
+	// are redacted. Only the names are stored, the values are read back from
+	// the running service when the state is loaded.
+	SensitiveEnv []string
 }
 
 
+func hashCmdline(cmdline string) string {
+	sum := sha256.Sum256([]byte(cmdline))
+	return hex.EncodeToString(sum[:])
+}
+
 type Driver int64
 
 func (driver Driver) String() string {
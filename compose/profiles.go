@@ -0,0 +1,64 @@
+package compose
+
+import "strings"
+
+// ProfilesEnv selects the enabled profiles, as a comma separated list, when
+// none are given on the command line.
+const ProfilesEnv = "GOMPOSE_PROFILES"
+
+// ParseProfiles splits a comma separated list of profiles.
+func ParseProfiles(value string) []string {
+	var profiles []string
+	for _, profile := range strings.Split(value, ",") {
+		if profile = strings.TrimSpace(profile); profile != "" {
+			profiles = append(profiles, profile)
+		}
+	}
+	return profiles
+}
+
+// WithProfiles returns the definition with only the enabled services: those
+// without profiles, those with one of the given profiles, and the services
+// they depend on. The profile * enables every service.
+func (definition Definition) WithProfiles(profiles []string) Definition {
+	selected := map[string]bool{}
+	for _, profile := range profiles {
+		selected[profile] = true
+	}
+	enabled := map[string]bool{}
+	var enable func(name string)
+	enable = func(name string) {
+		service, ok := definition.Services[name]
+		if !ok || enabled[name] {
+			return
+		}
+		enabled[name] = true
+		for _, dep := range service.DependsOn {
+			enable(dep)
+		}
+	}
+	for name, service := range definition.Services {
+		if service.enabled(selected) {
+			enable(name)
+		}
+	}
+
+	services := make(map[string]Service, len(enabled))
+	for name := range enabled {
+		services[name] = definition.Services[name]
+	}
+	definition.Services = services
+	return definition
+}
+
+func (s *Service) enabled(profiles map[string]bool) bool {
+	if len(s.Profiles) == 0 || profiles["*"] {
+		return true
+	}
+	for _, profile := range s.Profiles {
+		if profiles[profile] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package compose
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestParseProfiles(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{value: "", want: nil},
+		{value: "debug", want: []string{"debug"}},
+		{value: "debug, test", want: []string{"debug", "test"}},
+		{value: ",debug,,", want: []string{"debug"}},
+	}
+	for _, test := range tests {
+		if got := ParseProfiles(test.value); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseProfiles(%q) = %v, expected %v", test.value, got, test.want)
+		}
+	}
+}
+
+func TestWithProfiles(t *testing.T) {
+	definition := Definition{
+		Services: map[string]Service{
+			"db":      {},
+			"web":     {DependsOn: []string{"db"}},
+			"cache":   {Profiles: []string{"cache"}},
+			"debug":   {Profiles: []string{"debug"}, DependsOn: []string{"tools"}},
+			"tools":   {Profiles: []string{"tools"}},
+			"metrics": {Profiles: []string{"debug", "monitoring"}},
+		},
+	}
+	tests := []struct {
+		name     string
+		profiles []string
+		want     []string
+	}{
+		{name: "no profiles", want: []string{"db", "web"}},
+		{name: "single profile", profiles: []string{"cache"}, want: []string{"cache", "db", "web"}},
+		{name: "dependency of a profile", profiles: []string{"debug"}, want: []string{"db", "debug", "metrics", "tools", "web"}},
+		{name: "any of the profiles", profiles: []string{"monitoring"}, want: []string{"db", "metrics", "web"}},
+		{name: "unknown profile", profiles: []string{"unknown"}, want: []string{"db", "web"}},
+		{name: "every profile", profiles: []string{"*"}, want: []string{"cache", "db", "debug", "metrics", "tools", "web"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			for name := range definition.WithProfiles(test.profiles).Services {
+				got = append(got, name)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("WithProfiles(%v) enabled %v, expected %v", test.profiles, got, test.want)
+			}
+		})
+	}
+	if len(definition.Services) != 6 {
+		t.Errorf("WithProfiles modified the definition: %v", definition.Services)
+	}
+}
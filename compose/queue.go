@@ -0,0 +1,98 @@
+package compose
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+type operation struct {
+	id   uint64
+	name string
+	run  func() error
+	done chan error
+}
+
+// commandQueue serializes the operations that mutate the environment, so that
+// overlapping requests are executed one at a time in the order received.
+type commandQueue struct {
+	operations chan operation
+	mu         sync.Mutex
+	current    string
+	runningID  uint64
+	lastID     uint64
+	pending    int
+}
+
+func newCommandQueue() *commandQueue {
+	queue := &commandQueue{
+		operations: make(chan operation),
+	}
+	go queue.process()
+	return queue
+}
+
+func (queue *commandQueue) process() {
+	for op := range queue.operations {
+		queue.mu.Lock()
+		queue.current = op.name
+		queue.runningID = op.id
+		queue.mu.Unlock()
+
+		op.done <- op.run()
+
+		queue.mu.Lock()
+		queue.current = ""
+		queue.runningID = 0
+		queue.pending--
+		queue.mu.Unlock()
+	}
+}
+
+// Current returns the name of the operation currently being executed, and
+// the number of operations waiting, including the current one.
+func (queue *commandQueue) Current() (string, int) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	return queue.current, queue.pending
+}
+
+// newID returns a new operation id, which is never zero.
+func (queue *commandQueue) newID() uint64 {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	queue.lastID++
+	return queue.lastID
+}
+
+// currentID returns the id of the operation currently being executed, or zero
+// when the queue is idle.
+func (queue *commandQueue) currentID() uint64 {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	return queue.runningID
+}
+
+// run queues the operation and waits for it to complete. An id of zero is
+// replaced by a new id.
+func (queue *commandQueue) run(id uint64, name string, writer io.Writer, run func() error) error {
+	if id == 0 {
+		id = queue.newID()
+	}
+	queue.mu.Lock()
+	if queue.pending > 0 {
+		fmt.Fprintf(writer, "operation in progress: %s (%d pending), waiting to run %s\n",
+			queue.current, queue.pending, name)
+	}
+	queue.pending++
+	queue.mu.Unlock()
+
+	op := operation{
+		id:   id,
+		name: name,
+		run:  run,
+		done: make(chan error, 1),
+	}
+	queue.operations <- op
+	return <-op.done
+}
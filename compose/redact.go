@@ -0,0 +1,245 @@
+package compose
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RedactedValue replaces sensitive values in the output of gompose.
+const RedactedValue = "********"
+
+// minRedactedLength is the length below which values are not redacted from
+// output, as short values would replace unrelated text everywhere.
+const minRedactedLength = 6
+
+// DefaultRedactionPatterns match the names of the environment variables whose
+// values are redacted, unless the definition configures its own patterns.
+var DefaultRedactionPatterns = []string{
+	"*PASSWORD*",
+	"*PASSWD*",
+	"*SECRET*",
+	"*TOKEN*",
+	"*CREDENTIALS*",
+	"*_KEY",
+}
+
+// RedactionPolicy decides which values of the environment of the services
+// are sensitive: those whose name matches one of the patterns, and those
+// marked as sensitive. Patterns are matched case insensitively, with * and ?
+// as wildcards.
+type RedactionPolicy struct {
+	Patterns []string `yaml:"patterns,omitempty" json:"patterns,omitempty"`
+}
+
+func (policy RedactionPolicy) Validate() error {
+	for _, pattern := range policy.Patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid redaction pattern %s: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+func (policy RedactionPolicy) patterns() []string {
+	if len(policy.Patterns) == 0 {
+		return DefaultRedactionPatterns
+	}
+	return policy.Patterns
+}
+
+// Sensitive reports whether the value of the environment variable of the
+// service is redacted.
+func (policy RedactionPolicy) Sensitive(service Service, name string) bool {
+	for _, sensitive := range service.SensitiveEnv {
+		if sensitive == name {
+			return true
+		}
+	}
+	for _, pattern := range policy.patterns() {
+		if ok, _ := path.Match(strings.ToUpper(pattern), strings.ToUpper(name)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// sensitiveValues returns the sensitive values of the environment of the
+// service, including those read from its env files.
+func (policy RedactionPolicy) sensitiveValues(service Service) []string {
+	var values []string
+	for _, entry := range serviceEnv(service) {
+		if name, value := splitEnv(entry); value != "" && policy.Sensitive(service, name) {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// sensitiveNames returns the names of the sensitive environment variables of
+// the service, including those read from its env files.
+func (policy RedactionPolicy) sensitiveNames(service Service) []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, entry := range serviceEnv(service) {
+		if name, value := splitEnv(entry); value != "" && !seen[name] && policy.Sensitive(service, name) {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func serviceEnv(service Service) []string {
+	env := append([]string{}, service.Env...)
+	for _, file := range service.EnvFile {
+		if vars, err := parseEnvFile(file); err == nil {
+			env = append(env, vars...)
+		}
+	}
+	return env
+}
+
+// envValues returns the values of the given variables in the environment.
+func envValues(env []string, names []string) []string {
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+	var values []string
+	for _, entry := range env {
+		if name, value := splitEnv(entry); wanted[name] && value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// Redacted returns a copy of the definition with the sensitive values of the
+// environment of its services, and the inline content of its secrets,
+// replaced by RedactedValue.
+func (definition Definition) Redacted() Definition {
+	services := make(map[string]Service, len(definition.Services))
+	for name, service := range definition.Services {
+		env := make(Environment, len(service.Env))
+		for i, entry := range service.Env {
+			key, value := splitEnv(entry)
+			if value != "" && definition.Redaction.Sensitive(service, key) {
+				entry = key + "=" + RedactedValue
+			}
+			env[i] = entry
+		}
+		service.Env = env
+		services[name] = service
+	}
+	definition.Services = services
+
+	secrets := make(map[string]FileSource, len(definition.Secrets))
+	for name, source := range definition.Secrets {
+		if source.Content != "" {
+			source.Content = RedactedValue
+		}
+		secrets[name] = source
+	}
+	definition.Secrets = secrets
+	return definition
+}
+
+// Redactor replaces known sensitive values, such as the content of secrets,
+// with RedactedValue. Values shorter than minRedactedLength are ignored.
+type Redactor struct {
+	mu     sync.RWMutex
+	values map[string]bool
+	// sorted holds the values longest first, so that a value containing
+	// another is replaced as a whole.
+	sorted []string
+}
+
+func NewRedactor() *Redactor {
+	return &Redactor{values: map[string]bool{}}
+}
+
+// Add registers the values to redact. Each line of a value is redacted on
+// its own, so that multi-line values are redacted from line based output.
+func (redactor *Redactor) Add(values ...string) {
+	redactor.mu.Lock()
+	defer redactor.mu.Unlock()
+	added := false
+	for _, value := range values {
+		for _, line := range strings.Split(value, "\n") {
+			line = strings.TrimSpace(line)
+			if len(line) < minRedactedLength || redactor.values[line] {
+				continue
+			}
+			redactor.values[line] = true
+			redactor.sorted = append(redactor.sorted, line)
+			added = true
+		}
+	}
+	if added {
+		sort.SliceStable(redactor.sorted, func(i, j int) bool {
+			return len(redactor.sorted[i]) > len(redactor.sorted[j])
+		})
+	}
+}
+
+func (redactor *Redactor) Redact(text string) string {
+	redactor.mu.RLock()
+	defer redactor.mu.RUnlock()
+	for _, value := range redactor.sorted {
+		text = strings.Replace(text, value, RedactedValue, -1)
+	}
+	return text
+}
+
+// Error returns the error with the sensitive values redacted from its message.
+func (redactor *Redactor) Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	if msg := redactor.Redact(err.Error()); msg != err.Error() {
+		return errors.New(msg)
+	}
+	return err
+}
+
+// Writer returns a writer redacting each line before writing it to the given
+// writer. The last line is only written when the writer is closed, if it is
+// not terminated by a newline.
+func (redactor *Redactor) Writer(writer io.Writer) io.WriteCloser {
+	return &redactingWriter{redactor: redactor, writer: writer}
+}
+
+type redactingWriter struct {
+	redactor *Redactor
+	writer   io.Writer
+	buffer   []byte
+}
+
+func (w *redactingWriter) Write(p []byte) (int, error) {
+	w.buffer = append(w.buffer, p...)
+	end := bytes.LastIndexByte(w.buffer, '\n')
+	if end < 0 {
+		return len(p), nil
+	}
+	lines := w.redactor.Redact(string(w.buffer[:end+1]))
+	w.buffer = append(w.buffer[:0], w.buffer[end+1:]...)
+	if _, err := io.WriteString(w.writer, lines); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *redactingWriter) Close() error {
+	if len(w.buffer) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(w.writer, w.redactor.Redact(string(w.buffer)))
+	w.buffer = nil
+	return err
+}
@@ -0,0 +1,154 @@
+package compose
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRedactor(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		text   string
+		want   string
+	}{
+		{
+			name:   "value",
+			values: []string{"hunter22"},
+			text:   "password=hunter22",
+			want:   "password=" + RedactedValue,
+		},
+		{
+			name:   "every occurrence",
+			values: []string{"hunter22"},
+			text:   "hunter22 and hunter22",
+			want:   RedactedValue + " and " + RedactedValue,
+		},
+		{
+			name:   "longest first",
+			values: []string{"secret", "secret-token"},
+			text:   "token=secret-token",
+			want:   "token=" + RedactedValue,
+		},
+		{
+			name:   "short values ignored",
+			values: []string{"abc", "true"},
+			text:   "abc is true",
+			want:   "abc is true",
+		},
+		{
+			name:   "multi-line value",
+			values: []string{"-----BEGIN KEY-----\nMIIBOgIBAAJBAK\n-----END KEY-----\n"},
+			text:   "loaded MIIBOgIBAAJBAK",
+			want:   "loaded " + RedactedValue,
+		},
+		{
+			name: "no values",
+			text: "nothing to hide",
+			want: "nothing to hide",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			redactor := NewRedactor()
+			redactor.Add(test.values...)
+			if got := redactor.Redact(test.text); got != test.want {
+				t.Errorf("Redact(%q) = %q, expected %q", test.text, got, test.want)
+			}
+		})
+	}
+}
+
+func TestRedactorError(t *testing.T) {
+	redactor := NewRedactor()
+	redactor.Add("hunter22")
+	if err := redactor.Error(nil); err != nil {
+		t.Errorf("Error(nil) = %v, expected nil", err)
+	}
+	unchanged := errors.New("connection refused")
+	if err := redactor.Error(unchanged); err != unchanged {
+		t.Errorf("Error(%v) = %v, expected the same error", unchanged, err)
+	}
+	if err := redactor.Error(errors.New("bad password hunter22")); err.Error() != "bad password "+RedactedValue {
+		t.Errorf("Error returned %q", err)
+	}
+}
+
+func TestRedactorWriter(t *testing.T) {
+	redactor := NewRedactor()
+	redactor.Add("hunter22")
+	var buffer bytes.Buffer
+	writer := redactor.Writer(&buffer)
+	// the value is split across writes, so lines must be buffered
+	for _, chunk := range []string{"password=hun", "ter22\nnext ", "line hunter", "22"} {
+		if _, err := writer.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := "password=" + RedactedValue + "\n"; buffer.String() != want {
+		t.Errorf("before Close, wrote %q, expected %q", buffer.String(), want)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if want := "password=" + RedactedValue + "\nnext line " + RedactedValue; buffer.String() != want {
+		t.Errorf("after Close, wrote %q, expected %q", buffer.String(), want)
+	}
+}
+
+func TestSensitiveEnv(t *testing.T) {
+	service := Service{
+		Env:          Environment{"DB_PASSWORD=hunter22", "API_TOKEN=", "USER=admin", "KEY=abcdef", "DB_PASSWORD=other"},
+		SensitiveEnv: []string{"KEY"},
+	}
+	names := RedactionPolicy{}.sensitiveNames(service)
+	if want := []string{"DB_PASSWORD", "KEY"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("sensitiveNames = %q, expected %q", names, want)
+	}
+	env := []string{"PATH=/usr/bin", "DB_PASSWORD=hunter22", "KEY=abcdef", "USER=admin"}
+	if values, want := envValues(env, names), []string{"hunter22", "abcdef"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("envValues = %q, expected %q", values, want)
+	}
+}
+
+func TestRestoreRedactorSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gompose-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"container/0":         "container-secret",
+		"process/secrets/db":  "process-secret",
+		"process/configs/app": "not-a-secret",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := &App{
+		Containers: map[string]Process{
+			"db": {Status: RUNNING, FilesDir: filepath.Join(dir, "container")},
+		},
+		Processes: map[string]Process{
+			"app": {Status: RUNNING, FilesDir: filepath.Join(dir, "process")},
+		},
+		redactor: NewRedactor(),
+	}
+	app.restoreRedactor()
+	text := "container-secret process-secret not-a-secret"
+	want := RedactedValue + " " + RedactedValue + " not-a-secret"
+	if got := app.redactor.Redact(text); got != want {
+		t.Errorf("Redact(%q) = %q, expected %q", text, got, want)
+	}
+}
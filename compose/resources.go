@@ -0,0 +1,120 @@
+package compose
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Pungyeon/docker-gompose/utils"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-units"
+)
+
+type Ulimit struct {
+	Soft int64
+	Hard int64
+}
+
+// UnmarshalYAML accepts both the single value form (nproc: 65535) and the
+// soft/hard form (nofile: {soft: 20000, hard: 40000}) of a ulimit.
+func (ulimit *Ulimit) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value int64
+	if err := unmarshal(&value); err == nil {
+		ulimit.Soft = value
+		ulimit.Hard = value
+		return nil
+	}
+	var limits struct {
+		Soft int64
+		Hard int64
+	}
+	if err := unmarshal(&limits); err != nil {
+		return err
+	}
+	ulimit.Soft = limits.Soft
+	ulimit.Hard = limits.Hard
+	return nil
+}
+
+func (s *Service) GetResources() (container.Resources, error) {
+	var resources container.Resources
+	var errs []error
+	if s.MemLimit != "" {
+		memory, err := units.RAMInBytes(s.MemLimit)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not parse mem_limit: %v", err))
+		}
+		resources.Memory = memory
+	}
+	if s.MemReservation != "" {
+		memory, err := units.RAMInBytes(s.MemReservation)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not parse mem_reservation: %v", err))
+		}
+		resources.MemoryReservation = memory
+	}
+	if s.CPUs < 0 {
+		errs = append(errs, fmt.Errorf("invalid cpus value: %v", s.CPUs))
+	}
+	resources.NanoCPUs = int64(s.CPUs * 1e9)
+	resources.CPUShares = s.CPUShares
+	resources.CpusetCpus = s.Cpuset
+
+	for name, limit := range s.Ulimits {
+		if limit.Soft > limit.Hard {
+			errs = append(errs, fmt.Errorf("ulimit %s: soft limit %d exceeds hard limit %d", name, limit.Soft, limit.Hard))
+			continue
+		}
+		resources.Ulimits = append(resources.Ulimits, &units.Ulimit{
+			Name: name,
+			Soft: limit.Soft,
+			Hard: limit.Hard,
+		})
+	}
+
+	for _, device := range s.Devices {
+		mapping, err := NewDeviceMapping(device)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		resources.Devices = append(resources.Devices, mapping)
+	}
+	return resources, utils.CombineErrors(errs...)
+}
+
+func (s *Service) GetShmSize() (int64, error) {
+	if s.ShmSize == "" {
+		return 0, nil
+	}
+	size, err := units.RAMInBytes(s.ShmSize)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse shm_size: %v", err)
+	}
+	return size, nil
+}
+
+func NewDeviceMapping(deviceStr string) (container.DeviceMapping, error) {
+	paths := strings.Split(deviceStr, ":")
+	switch len(paths) {
+	case 1:
+		return container.DeviceMapping{
+			PathOnHost:        paths[0],
+			PathInContainer:   paths[0],
+			CgroupPermissions: "rwm",
+		}, nil
+	case 2:
+		return container.DeviceMapping{
+			PathOnHost:        paths[0],
+			PathInContainer:   paths[1],
+			CgroupPermissions: "rwm",
+		}, nil
+	case 3:
+		return container.DeviceMapping{
+			PathOnHost:        paths[0],
+			PathInContainer:   paths[1],
+			CgroupPermissions: paths[2],
+		}, nil
+	default:
+		return container.DeviceMapping{}, fmt.Errorf("invalid device mapping: %v", deviceStr)
+	}
+}
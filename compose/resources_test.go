@@ -0,0 +1,37 @@
+package compose
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestUlimitUnmarshalYAML(t *testing.T) {
+	tests := []struct {
+		yaml string
+		want map[string]Ulimit
+		err  bool
+	}{
+		{yaml: "nproc: 65535", want: map[string]Ulimit{"nproc": {Soft: 65535, Hard: 65535}}},
+		{yaml: "nofile: {soft: 20000, hard: 40000}", want: map[string]Ulimit{"nofile": {Soft: 20000, Hard: 40000}}},
+		{yaml: "nofile: many", err: true},
+	}
+	for _, test := range tests {
+		var ulimits map[string]Ulimit
+		err := yaml.Unmarshal([]byte(test.yaml), &ulimits)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: expected an error", test.yaml)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.yaml, err)
+			continue
+		}
+		if !reflect.DeepEqual(ulimits, test.want) {
+			t.Errorf("%q: got %+v, expected %+v", test.yaml, ulimits, test.want)
+		}
+	}
+}
@@ -0,0 +1,427 @@
+package compose
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Pungyeon/docker-gompose/utils"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+)
+
+const (
+	secretsDir = "/run/secrets"
+
+	// SecretsDirEnv and ConfigsDirEnv tell EXEC services where their secrets
+	// and configs have been written.
+	SecretsDirEnv = "GOMPOSE_SECRETS_DIR"
+	ConfigsDirEnv = "GOMPOSE_CONFIGS_DIR"
+
+	defaultFileMode = 0444
+)
+
+// FileSource is a secret or config declared at the top level of the
+// definition. Its content is read from a file, from an environment variable
+// or given inline. Files and environment variables are read by the client,
+// which sends their content inline, so that the server never reads its own
+// files or environment on behalf of a client.
+type FileSource struct {
+	File        string `yaml:"file,omitempty" json:"file,omitempty"`
+	Environment string `yaml:"environment,omitempty" json:"environment,omitempty"`
+	Content     string `yaml:"content,omitempty" json:"content,omitempty"`
+}
+
+func (source FileSource) Validate() error {
+	set := 0
+	for _, value := range []string{source.File, source.Environment, source.Content} {
+		if value != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("exactly one of file, environment or content must be given")
+	}
+	return nil
+}
+
+// inline returns the source with the content of its file or environment
+// variable given inline. Relative files are read from dir.
+func (source FileSource) inline(dir string) (FileSource, error) {
+	switch {
+	case source.File != "":
+		file := source.File
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return source, err
+		}
+		return FileSource{Content: string(content)}, nil
+	case source.Environment != "":
+		value, ok := os.LookupEnv(source.Environment)
+		if !ok {
+			return source, fmt.Errorf("environment variable %s is not set", source.Environment)
+		}
+		return FileSource{Content: value}, nil
+	default:
+		return source, nil
+	}
+}
+
+// Inline returns the definition with the content of the files and
+// environment variables of its secrets and configs given inline, reading
+// relative files from dir.
+func (definition Definition) Inline(dir string) (Definition, error) {
+	var errs []error
+	inline := func(kind string, sources map[string]FileSource) map[string]FileSource {
+		if sources == nil {
+			return nil
+		}
+		inlined := make(map[string]FileSource, len(sources))
+		for name, source := range sources {
+			source, err := source.inline(dir)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("could not read %s %s: %v", kind, name, err))
+			}
+			inlined[name] = source
+		}
+		return inlined
+	}
+	definition.Secrets = inline("secret", definition.Secrets)
+	definition.Configs = inline("config", definition.Configs)
+	return definition, utils.CombineErrors(errs...)
+}
+
+// FileReference references a secret or config from a service, either by name
+// only, or with the target path and the owner and mode of the file.
+type FileReference struct {
+	Source string `yaml:"source" json:"source"`
+	Target string `yaml:"target,omitempty" json:"target,omitempty"`
+	UID    string `yaml:"uid,omitempty" json:"uid,omitempty"`
+	GID    string `yaml:"gid,omitempty" json:"gid,omitempty"`
+	Mode   uint32 `yaml:"mode,omitempty" json:"mode,omitempty"`
+}
+
+func (ref *FileReference) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var source string
+	if err := unmarshal(&source); err == nil {
+		*ref = FileReference{Source: source}
+		return nil
+	}
+	type plain FileReference
+	return unmarshal((*plain)(ref))
+}
+
+func (ref FileReference) Validate(exec bool) error {
+	var errs []error
+	for _, id := range []string{ref.UID, ref.GID} {
+		if id == "" {
+			continue
+		}
+		if _, err := strconv.Atoi(id); err != nil {
+			errs = append(errs, fmt.Errorf("invalid uid or gid, expected a number: %v", id))
+		}
+	}
+	if ref.Mode > 0777 {
+		errs = append(errs, fmt.Errorf("invalid mode: %o", ref.Mode))
+	}
+	if exec && (path.IsAbs(ref.Target) || strings.HasPrefix(path.Clean(ref.Target), "..")) {
+		errs = append(errs, fmt.Errorf("the target of EXEC services must be a relative path inside their directory: %v", ref.Target))
+	}
+	return utils.CombineErrors(errs...)
+}
+
+// serviceFile is a secret or config resolved for a service, ready to be
+// written into its container or temporary directory. A negative UID or GID
+// is not set by the definition.
+type serviceFile struct {
+	Path    string
+	Content []byte
+	UID     int
+	GID     int
+	Mode    os.FileMode
+	Secret  bool
+}
+
+func validateFileReferences(kind string, refs []FileReference, sources map[string]FileSource, exec bool) []error {
+	var errs []error
+	for _, ref := range refs {
+		if _, ok := sources[ref.Source]; !ok {
+			errs = append(errs, fmt.Errorf("undefined %s: %s", kind, ref.Source))
+		}
+		if err := ref.Validate(exec); err != nil {
+			errs = append(errs, fmt.Errorf("%s %s: %v", kind, ref.Source, err))
+		}
+	}
+	return errs
+}
+
+// resolveServices returns the services of the definition with the content of
+// their secrets and configs read, adding the secrets and the sensitive values
+// of their environment to the redacted values.
+func (app *App) resolveServices(definition Definition) (map[string]Service, error) {
+	services := make(map[string]Service, len(definition.Services))
+	var errs []error
+	for name, service := range definition.Services {
+		resolved, err := app.resolveService(definition, service)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("service %s: %v", name, err))
+		}
+		services[name] = resolved
+	}
+	return services, utils.CombineErrors(errs...)
+}
+
+func (app *App) resolveService(definition Definition, service Service) (Service, error) {
+	app.redactor.Add(definition.Redaction.sensitiveValues(service)...)
+	service.sensitiveEnv = definition.Redaction.sensitiveNames(service)
+	service.files = nil
+	var errs []error
+	for _, ref := range service.Secrets {
+		file, err := resolveFile(ref, definition.Secrets, secretsDir, true)
+		errs = append(errs, err)
+		if err == nil {
+			app.redactor.Add(string(file.Content))
+		}
+		service.files = append(service.files, file)
+	}
+	for _, ref := range service.Configs {
+		file, err := resolveFile(ref, definition.Configs, "/", false)
+		errs = append(errs, err)
+		service.files = append(service.files, file)
+	}
+	return service, utils.CombineErrors(errs...)
+}
+
+func resolveFile(ref FileReference, sources map[string]FileSource, dir string, secret bool) (serviceFile, error) {
+	source, ok := sources[ref.Source]
+	if !ok {
+		return serviceFile{}, fmt.Errorf("undefined secret or config: %s", ref.Source)
+	}
+	if source.File != "" || source.Environment != "" {
+		return serviceFile{}, fmt.Errorf("%s must be given inline, its file or environment variable is read by the client", ref.Source)
+	}
+	target := ref.Target
+	if target == "" {
+		target = ref.Source
+	}
+	if !path.IsAbs(target) {
+		target = path.Join(dir, target)
+	}
+	mode := os.FileMode(defaultFileMode)
+	if ref.Mode != 0 {
+		mode = os.FileMode(ref.Mode)
+	}
+	return serviceFile{
+		Path:    target,
+		Content: []byte(source.Content),
+		UID:     parseOwnerID(ref.UID),
+		GID:     parseOwnerID(ref.GID),
+		Mode:    mode,
+		Secret:  secret,
+	}, nil
+}
+
+// parseOwnerID returns the validated uid or gid, or -1 when it is not set.
+func parseOwnerID(id string) int {
+	if id == "" {
+		return -1
+	}
+	n, _ := strconv.Atoi(id)
+	return n
+}
+
+// ownerOrRoot returns the uid or gid, defaulting to root when it is not set.
+func ownerOrRoot(id int) int {
+	if id < 0 {
+		return 0
+	}
+	return id
+}
+
+// writeContainerSecrets writes the secrets of a container into a private
+// directory of the host, held in memory where the host has a tmpfs for it,
+// and returns the directory and the read-only bind mounts of the secrets.
+// Secrets are never copied into the writable layer of the container. The
+// directory must be removed once the container has been removed.
+func writeContainerSecrets(name string, files []serviceFile) (string, []mount.Mount, error) {
+	var secrets []serviceFile
+	for _, file := range files {
+		if file.Secret {
+			secrets = append(secrets, file)
+		}
+	}
+	if len(secrets) == 0 {
+		return "", nil, nil
+	}
+	dir, err := ioutil.TempDir(filesBaseDir(), "gompose-"+name+"-")
+	if err != nil {
+		return "", nil, err
+	}
+	mounts := make([]mount.Mount, 0, len(secrets))
+	for i, file := range secrets {
+		source := filepath.Join(dir, strconv.Itoa(i))
+		if err := writeServiceFile(source, file); err != nil {
+			os.RemoveAll(dir)
+			return "", nil, err
+		}
+		mounts = append(mounts, mount.Mount{
+			Type:     mount.TypeBind,
+			Source:   source,
+			Target:   file.Path,
+			ReadOnly: true,
+		})
+	}
+	return dir, mounts, nil
+}
+
+// filesBaseDir returns the directory in which the secrets and configs of the
+// services are written, which is in memory where the host has a tmpfs for it.
+func filesBaseDir() string {
+	if info, err := os.Stat("/dev/shm"); err == nil && info.IsDir() {
+		return "/dev/shm"
+	}
+	return ""
+}
+
+// copyFilesToContainer copies the configs of the service into the created
+// container, before it is started. Secrets are mounted instead, see
+// writeContainerSecrets.
+func (app *App) copyFilesToContainer(ctx context.Context, id string, files []serviceFile) error {
+	buffer := &bytes.Buffer{}
+	archive := tar.NewWriter(buffer)
+	copied := 0
+	for _, file := range files {
+		if file.Secret {
+			continue
+		}
+		copied++
+		if err := archive.WriteHeader(&tar.Header{
+			Name:     file.Path[1:],
+			Typeflag: tar.TypeReg,
+			Mode:     int64(file.Mode),
+			Uid:      ownerOrRoot(file.UID),
+			Gid:      ownerOrRoot(file.GID),
+			Size:     int64(len(file.Content)),
+			ModTime:  time.Now(),
+		}); err != nil {
+			return err
+		}
+		if _, err := archive.Write(file.Content); err != nil {
+			return err
+		}
+	}
+	if copied == 0 {
+		return nil
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return app.cli.CopyToContainer(ctx, id, "/", buffer, types.CopyToContainerOptions{})
+}
+
+// writeServiceFiles writes the secrets and configs of an EXEC service into a
+// private temporary directory, returning the directory and the environment
+// pointing the service to it. Unless uid is negative, the directory and the
+// files without an owner of their own belong to the given uid and gid, which
+// the service runs as. The directory is held in memory where the host has a
+// tmpfs for it. The directory must be removed once the service has
+// exited.
+func writeServiceFiles(name string, files []serviceFile, uid, gid int) (string, []string, error) {
+	if len(files) == 0 {
+		return "", nil, nil
+	}
+	dir, err := ioutil.TempDir(filesBaseDir(), "gompose-"+name+"-")
+	if err != nil {
+		return "", nil, err
+	}
+	for _, file := range files {
+		if file.UID < 0 && file.GID < 0 && uid >= 0 {
+			file.UID, file.GID = uid, gid
+		}
+		base := filepath.Join(dir, "configs")
+		relative := file.Path
+		if file.Secret {
+			base = filepath.Join(dir, "secrets")
+			relative = strings.TrimPrefix(relative, secretsDir)
+		}
+		relative = strings.TrimPrefix(relative, "/")
+		if err := writeServiceFile(filepath.Join(base, filepath.FromSlash(relative)), file); err != nil {
+			os.RemoveAll(dir)
+			return "", nil, err
+		}
+	}
+	if uid >= 0 {
+		if err := chownDirs(dir, uid, gid); err != nil {
+			os.RemoveAll(dir)
+			return "", nil, err
+		}
+	}
+	return dir, []string{
+		SecretsDirEnv + "=" + filepath.Join(dir, "secrets"),
+		ConfigsDirEnv + "=" + filepath.Join(dir, "configs"),
+	}, nil
+}
+
+// chownDirs changes the owner of the directory and of every directory in it.
+func chownDirs(dir string, uid, gid int) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return err
+		}
+		return os.Chown(path, uid, gid)
+	})
+}
+
+// readSecretFiles returns the content of the files in the directory.
+func readSecretFiles(dir string) []string {
+	if dir == "" {
+		return nil
+	}
+	var contents []string
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		if content, err := ioutil.ReadFile(path); err == nil {
+			contents = append(contents, string(content))
+		}
+		return nil
+	})
+	return contents
+}
+
+func removeServiceFiles(dir string) {
+	if dir == "" {
+		return
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		log.Println(err)
+	}
+}
+
+func writeServiceFile(target string, file serviceFile) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(target, file.Content, 0600); err != nil {
+		return err
+	}
+	if file.UID >= 0 || file.GID >= 0 {
+		if err := os.Chown(target, file.UID, file.GID); err != nil {
+			return err
+		}
+	}
+	return os.Chmod(target, file.Mode)
+}
@@ -0,0 +1,92 @@
+package compose
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDefinitionInline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gompose-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "password.txt"), []byte("hunter2"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("GOMPOSE_TEST_TOKEN", "abc")
+	defer os.Unsetenv("GOMPOSE_TEST_TOKEN")
+
+	definition := Definition{
+		Secrets: map[string]FileSource{
+			"password": {File: "password.txt"},
+			"token":    {Environment: "GOMPOSE_TEST_TOKEN"},
+			"inline":   {Content: "x"},
+		},
+	}
+	inlined, err := definition.Inline(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]FileSource{
+		"password": {Content: "hunter2"},
+		"token":    {Content: "abc"},
+		"inline":   {Content: "x"},
+	}
+	if !reflect.DeepEqual(inlined.Secrets, want) {
+		t.Errorf("secrets = %+v, expected %+v", inlined.Secrets, want)
+	}
+	if definition.Secrets["password"].File != "password.txt" {
+		t.Errorf("the definition was modified: %+v", definition.Secrets)
+	}
+
+	for _, source := range []FileSource{{File: "missing.txt"}, {Environment: "GOMPOSE_TEST_UNSET"}} {
+		if _, err := (Definition{Configs: map[string]FileSource{"c": source}}).Inline(dir); err == nil {
+			t.Errorf("Inline(%+v) expected an error", source)
+		}
+	}
+}
+
+func TestResolveFile(t *testing.T) {
+	sources := map[string]FileSource{
+		"inline": {Content: "x"},
+		"file":   {File: "/etc/shadow"},
+		"env":    {Environment: "HOME"},
+	}
+	tests := []struct {
+		ref  FileReference
+		want serviceFile
+		err  bool
+	}{
+		{
+			ref:  FileReference{Source: "inline"},
+			want: serviceFile{Path: "/run/secrets/inline", Content: []byte("x"), UID: -1, GID: -1, Mode: defaultFileMode, Secret: true},
+		},
+		{
+			ref:  FileReference{Source: "inline", Target: "db", UID: "0", GID: "10", Mode: 0400},
+			want: serviceFile{Path: "/run/secrets/db", Content: []byte("x"), UID: 0, GID: 10, Mode: 0400, Secret: true},
+		},
+		{ref: FileReference{Source: "file"}, err: true},
+		{ref: FileReference{Source: "env"}, err: true},
+		{ref: FileReference{Source: "undefined"}, err: true},
+	}
+	for _, test := range tests {
+		file, err := resolveFile(test.ref, sources, secretsDir, true)
+		if test.err {
+			if err == nil {
+				t.Errorf("resolveFile(%+v) = %+v, expected an error", test.ref, file)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("resolveFile(%+v) returned an error: %v", test.ref, err)
+			continue
+		}
+		if !reflect.DeepEqual(file, test.want) {
+			t.Errorf("resolveFile(%+v) = %+v, expected %+v", test.ref, file, test.want)
+		}
+	}
+}
@@ -2,30 +2,152 @@ package compose
 
 import (
 	"fmt"
+	"github.com/Pungyeon/docker-gompose/utils"
 	"github.com/docker/docker/api/types/strslice"
 	"github.com/docker/go-connections/nat"
 	"strings"
+	"time"
 )
 
 type Service struct {
 	Image      string
 	Entrypoint string
-	Env        []string
+	Env        Environment
 	Volumes    []string
 	Driver     string
 	Command    string
 	OnStop     string `yaml:"on_stop"`
 	Ports      []string
+	Networks   []string
+	Profiles   []string
 	DependsOn  []string `yaml:"depends_on"`
 	RestartPolicy RestartPolicy `yaml:"restart"`
 	StopSignal string `yaml:"stop_signal"`
+
+	MemLimit       string            `yaml:"mem_limit"`
+	MemReservation string            `yaml:"mem_reservation"`
+	CPUs           float64           `yaml:"cpus"`
+	CPUShares      int64             `yaml:"cpu_shares"`
+	Cpuset         string            `yaml:"cpuset"`
+	Ulimits        map[string]Ulimit `yaml:"ulimits"`
+	CapAdd         []string          `yaml:"cap_add"`
+	CapDrop        []string          `yaml:"cap_drop"`
+	Privileged     bool              `yaml:"privileged"`
+	User           string            `yaml:"user"`
+	WorkingDir     string            `yaml:"working_dir"`
+	ShmSize        string            `yaml:"shm_size"`
+	Sysctls        map[string]string `yaml:"sysctls"`
+	ExtraHosts     []string          `yaml:"extra_hosts"`
+	DNS            []string          `yaml:"dns"`
+	Labels         map[string]string `yaml:"labels"`
+	Init           *bool             `yaml:"init"`
+	ReadOnly       bool              `yaml:"read_only"`
+	SecurityOpt    []string          `yaml:"security_opt"`
+	Devices        []string          `yaml:"devices"`
+	EnvFile        []string          `yaml:"env_file"`
+	Nice           int               `yaml:"nice"`
+	Tty            bool              `yaml:"tty"`
+	StdinOpen      bool              `yaml:"stdin_open"`
+	// StopGracePeriod is a duration such as 10s or 1m30s.
+	StopGracePeriod string          `yaml:"stop_grace_period"`
+	Secrets         []FileReference `yaml:"secrets"`
+	Configs         []FileReference `yaml:"configs"`
+	// SensitiveEnv are the names of the environment variables marked as
+	// sensitive, either in env or listed here.
+	SensitiveEnv []string `yaml:"sensitive_env,omitempty"`
+
+	// files are the secrets and configs of the service, once resolved.
+	files []serviceFile
+	// sensitiveEnv are the names of the sensitive environment variables of
+	// the service, once resolved.
+	sensitiveEnv []string
+}
+
+func (s *Service) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Service
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	var marked struct {
+		Env []EnvVar `yaml:"env"`
+	}
+	if err := unmarshal(&marked); err != nil {
+		return err
+	}
+	listed := map[string]bool{}
+	for _, name := range s.SensitiveEnv {
+		listed[name] = true
+	}
+	for _, v := range marked.Env {
+		if v.Sensitive && !listed[v.Name] {
+			s.SensitiveEnv = append(s.SensitiveEnv, v.Name)
+			listed[v.Name] = true
+		}
+	}
+	return nil
+}
+
+func (s *Service) Validate() error {
+	var errs []error
+	driver := DriverFromString(s.Driver)
+	switch {
+	case s.Driver != "" && driver == UNKNOWN_DRIVER:
+		errs = append(errs, fmt.Errorf("unknown driver: %v", s.Driver))
+	case driver == EXEC:
+		if strings.TrimSpace(s.Command) == "" {
+			errs = append(errs, fmt.Errorf("no command specified for EXEC service"))
+		}
+		if s.Tty {
+			errs = append(errs, fmt.Errorf("tty is not supported for EXEC services"))
+		}
+		if len(s.Networks) != 0 {
+			errs = append(errs, fmt.Errorf("networks are not supported for EXEC services"))
+		}
+	default:
+		if _, err := ParseImageReference(s.Image); err != nil {
+			errs = append(errs, err)
+		}
+		for _, v := range s.Volumes {
+			if _, err := NewVolume(v); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if _, err := s.GetPortBindings(); err != nil {
+			errs = append(errs, err)
+		}
+		if _, err := s.GetShmSize(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if s.RestartPolicy.Condition != "" {
+		errs = append(errs, s.RestartPolicy.Validate())
+	}
+	if _, err := s.GetResources(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := s.GetStopGracePeriod(); err != nil {
+		errs = append(errs, err)
+	}
+	return utils.CombineErrors(errs...)
+}
+
+func (s *Service) GetStopGracePeriod() (time.Duration, error) {
+	if s.StopGracePeriod == "" {
+		return 0, nil
+	}
+	period, err := time.ParseDuration(s.StopGracePeriod)
+	if err != nil || period < 0 {
+		return 0, fmt.Errorf("invalid stop_grace_period: %v", s.StopGracePeriod)
+	}
+	return period, nil
 }
 
 func (s *Service) GetImage() string {
-	if len(strings.Split(s.Image, "/")) != 0 {
+	ref, err := ParseImageReference(s.Image)
+	if err != nil {
 		return s.Image
 	}
-	return "docker.io/library/" + s.Image
+	return ref.Normalized().String()
 }
 
 func (s *Service) GetEntrypoint() strslice.StrSlice {
@@ -37,10 +159,12 @@ func (s *Service) GetEntrypoint() strslice.StrSlice {
 
 func (s *Service) GetPortBindings() (nat.PortMap, error) {
 	portmap := nat.PortMap{}
+	var errs []error
 	for _, port := range s.Ports {
 		pb, err := NewPortBinding(port)
 		if err != nil {
-			return nil, err
+			errs = append(errs, err)
+			continue
 		}
 		if _, ok := portmap[pb.Container]; !ok {
 			portmap[pb.Container] = []nat.PortBinding{}
@@ -50,6 +174,9 @@ func (s *Service) GetPortBindings() (nat.PortMap, error) {
 			HostPort: pb.Host,
 		})
 	}
+	if err := utils.CombineErrors(errs...); err != nil {
+		return nil, err
+	}
 	return portmap, nil
 }
 
@@ -0,0 +1,245 @@
+package compose
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/corticph/go-logging/pkg/logging"
+)
+
+const (
+	lockFileName = ".gompose.lock"
+	stateVersion = 1
+)
+
+// State is the persisted representation of an App. Any change to its fields
+// must bump stateVersion and add a migration from the previous version.
+type State struct {
+	Version          int                `json:"version"`
+	Volumes          map[string]string  `json:"volumes"`
+	ExternalVolumes  map[string]string  `json:"external_volumes"`
+	NetworkID        string             `json:"network_id"`
+	ExternalNetworks map[string]string  `json:"external_networks"`
+	Containers       map[string]Process `json:"containers"`
+	Processes        map[string]Process `json:"processes"`
+	Images           map[string]string  `json:"images"`
+}
+
+func NewState() State {
+	return State{
+		Version:          stateVersion,
+		Volumes:          map[string]string{},
+		ExternalVolumes:  map[string]string{},
+		ExternalNetworks: map[string]string{},
+		Containers:       map[string]Process{},
+		Processes:        map[string]Process{},
+		Images:           map[string]string{},
+	}
+}
+
+// migrations[i] migrates a raw state from version i to version i+1.
+var migrations = []func(raw map[string]json.RawMessage) error{
+	migrateLegacyState,
+}
+
+// migrateLegacyState converts the lock files written before the state was
+// versioned, which were the JSON encoding of the App struct itself. Their
+// processes stored the command line as is, which is replaced by its hash, so
+// that sensitive values are not persisted.
+func migrateLegacyState(raw map[string]json.RawMessage) error {
+	renames := map[string]string{
+		"Volumes":    "volumes",
+		"NetworkID":  "network_id",
+		"Containers": "containers",
+		"Processes":  "processes",
+		"Images":     "images",
+	}
+	for from, to := range renames {
+		if value, ok := raw[from]; ok {
+			raw[to] = value
+			delete(raw, from)
+		}
+	}
+	for _, key := range []string{"containers", "processes"} {
+		data, ok := raw[key]
+		if !ok {
+			continue
+		}
+		var processes map[string]map[string]json.RawMessage
+		if err := json.Unmarshal(data, &processes); err != nil {
+			return err
+		}
+		for _, proc := range processes {
+			value, ok := proc["Cmdline"]
+			if !ok {
+				continue
+			}
+			var cmdline string
+			if err := json.Unmarshal(value, &cmdline); err != nil {
+				return err
+			}
+			hash, err := json.Marshal(hashCmdline(cmdline))
+			if err != nil {
+				return err
+			}
+			delete(proc, "Cmdline")
+			proc["CmdlineHash"] = hash
+		}
+		migrated, err := json.Marshal(processes)
+		if err != nil {
+			return err
+		}
+		raw[key] = migrated
+	}
+	return nil
+}
+
+func decodeState(data []byte) (State, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return State{}, fmt.Errorf("could not parse state: %v", err)
+	}
+	version := 0
+	if v, ok := raw["version"]; ok {
+		if err := json.Unmarshal(v, &version); err != nil {
+			return State{}, fmt.Errorf("could not parse state version: %v", err)
+		}
+	}
+	if version > stateVersion {
+		return State{}, fmt.Errorf("state version %d is newer than supported version %d", version, stateVersion)
+	}
+	for ; version < stateVersion; version++ {
+		if err := migrations[version](raw); err != nil {
+			return State{}, fmt.Errorf("could not migrate state from version %d: %v", version, err)
+		}
+	}
+	migrated, err := json.Marshal(raw)
+	if err != nil {
+		return State{}, err
+	}
+	state := NewState()
+	if err := json.Unmarshal(migrated, &state); err != nil {
+		return State{}, fmt.Errorf("could not parse state: %v", err)
+	}
+	state.Version = stateVersion
+	state.fillDefaults()
+	return state, nil
+}
+
+func (state *State) fillDefaults() {
+	if state.Volumes == nil {
+		state.Volumes = map[string]string{}
+	}
+	if state.ExternalVolumes == nil {
+		state.ExternalVolumes = map[string]string{}
+	}
+	if state.ExternalNetworks == nil {
+		state.ExternalNetworks = map[string]string{}
+	}
+	if state.Containers == nil {
+		state.Containers = map[string]Process{}
+	}
+	if state.Processes == nil {
+		state.Processes = map[string]Process{}
+	}
+	if state.Images == nil {
+		state.Images = map[string]string{}
+	}
+}
+
+// LockFile persists the state as JSON. Writes are atomic, the previous state
+// is kept as a backup, and an advisory lock ensures that only a single
+// gompose server uses the directory at a time.
+type LockFile struct {
+	path string
+	lock *os.File
+}
+
+// LockedError is returned when the state is locked by another gompose
+// process, usually a server managing the project.
+type LockedError struct {
+	Path string
+	Err  error
+}
+
+func (err LockedError) Error() string {
+	return fmt.Sprintf("could not lock %s, is another gompose server running in this directory? %v", err.Path, err.Err)
+}
+
+func OpenLockFile(dir string) (*LockFile, error) {
+	path := filepath.Join(dir, lockFileName)
+	lock, err := os.OpenFile(path+".lck", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(lock); err != nil {
+		lock.Close()
+		return nil, LockedError{Path: path, Err: err}
+	}
+	return &LockFile{
+		path: path,
+		lock: lock,
+	}, nil
+}
+
+func (file *LockFile) Load() (State, error) {
+	data, err := ioutil.ReadFile(file.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			logging.Info("no lock file found, creating new environment")
+			return NewState(), nil
+		}
+		return State{}, err
+	}
+	return decodeState(data)
+}
+
+func (file *LockFile) Save(state State, operation string) error {
+	state.Version = stateVersion
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if previous, err := ioutil.ReadFile(file.path); err == nil {
+		if err := writeFileAtomic(file.path+".bak", previous); err != nil {
+			return fmt.Errorf("could not back up state: %v", err)
+		}
+	}
+	return writeFileAtomic(file.path, data)
+}
+
+func (file *LockFile) Close() error {
+	if err := unlockFile(file.lock); err != nil {
+		file.lock.Close()
+		return err
+	}
+	return file.lock.Close()
+}
+
+// writeFileAtomic writes to a temporary file in the same directory and renames
+// it into place, so a crash never leaves a partially written file behind.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
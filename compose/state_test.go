@@ -0,0 +1,96 @@
+package compose
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestDecodeState(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		check func(t *testing.T, state State)
+		err   bool
+	}{
+		{
+			name: "legacy",
+			data: `{"Volumes":{"data":"vol1"},"NetworkID":"net1","Containers":{"db":{"ID":"c1","Cmdline":"postgres -p 5432"}},"Processes":{"web":{"PID":42,"Cmdline":"./web --token secret"}},"Images":{"db":"postgres"}}`,
+			check: func(t *testing.T, state State) {
+				if state.NetworkID != "net1" {
+					t.Errorf("NetworkID = %q, expected net1", state.NetworkID)
+				}
+				if !reflect.DeepEqual(state.Volumes, map[string]string{"data": "vol1"}) {
+					t.Errorf("Volumes = %v", state.Volumes)
+				}
+				if !reflect.DeepEqual(state.Images, map[string]string{"db": "postgres"}) {
+					t.Errorf("Images = %v", state.Images)
+				}
+				if db := state.Containers["db"]; db.ID != "c1" || db.CmdlineHash != hashCmdline("postgres -p 5432") {
+					t.Errorf("Containers[db] = %+v", db)
+				}
+				if web := state.Processes["web"]; web.PID != 42 || web.CmdlineHash != hashCmdline("./web --token secret") {
+					t.Errorf("Processes[web] = %+v", web)
+				}
+			},
+		},
+		{
+			name: "current",
+			data: `{"version":1,"external_volumes":{"data":"shared"},"processes":{"web":{"PID":42,"CmdlineHash":"abc"}}}`,
+			check: func(t *testing.T, state State) {
+				if web := state.Processes["web"]; web.CmdlineHash != "abc" {
+					t.Errorf("Processes[web] = %+v", web)
+				}
+				if !reflect.DeepEqual(state.ExternalVolumes, map[string]string{"data": "shared"}) {
+					t.Errorf("ExternalVolumes = %v", state.ExternalVolumes)
+				}
+				if state.Volumes == nil || state.Containers == nil || state.ExternalNetworks == nil {
+					t.Errorf("missing fields were not filled: %+v", state)
+				}
+			},
+		},
+		{name: "newer version", data: `{"version":2}`, err: true},
+		{name: "invalid version", data: `{"version":"1"}`, err: true},
+		{name: "invalid json", data: `{`, err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state, err := decodeState([]byte(test.data))
+			if test.err {
+				if err == nil {
+					t.Fatalf("decodeState(%s) = %+v, expected an error", test.data, state)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeState(%s) returned an error: %v", test.data, err)
+			}
+			if state.Version != stateVersion {
+				t.Errorf("Version = %d, expected %d", state.Version, stateVersion)
+			}
+			test.check(t, state)
+		})
+	}
+}
+
+func TestOpenLockFileLocked(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("advisory locking is not supported on windows")
+	}
+	dir, err := ioutil.TempDir("", "gompose")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file, err := OpenLockFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	_, err = OpenLockFile(dir)
+	if _, ok := err.(LockedError); !ok {
+		t.Fatalf("OpenLockFile returned %v, expected a LockedError", err)
+	}
+}
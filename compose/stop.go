@@ -0,0 +1,289 @@
+package compose
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Pungyeon/docker-gompose/utils"
+	"github.com/corticph/go-logging/pkg/logging"
+	"github.com/docker/docker/api/types/container"
+)
+
+// exitCodeKilled is the exit code of a container killed with SIGKILL.
+const exitCodeKilled = 128 + 9
+
+// killSignalMessage reports services stopped with SIGKILL as their stop
+// signal, which are killed rather than stopped cleanly.
+const killSignalMessage = "killed by stop signal SIGKILL"
+
+// isKillSignal reports whether the stop signal is SIGKILL, in any of the
+// forms accepted by docker.
+func isKillSignal(signal string) bool {
+	switch strings.TrimPrefix(strings.ToUpper(signal), "SIG") {
+	case "KILL", "9":
+		return true
+	default:
+		return false
+	}
+}
+
+// stopOrder groups the services into waves that can be stopped in parallel.
+// A service is only stopped once every service depending on it has been
+// stopped, so the waves are in reverse dependency order. Services in a
+// dependency cycle are stopped together in the last wave.
+func stopOrder(dependencies map[string][]string) [][]string {
+	dependants := map[string]int{}
+	for name, deps := range dependencies {
+		dependants[name] += 0
+		for _, dep := range deps {
+			if _, ok := dependencies[dep]; ok {
+				dependants[dep]++
+			}
+		}
+	}
+
+	var waves [][]string
+	for len(dependants) > 0 {
+		var wave []string
+		for name, count := range dependants {
+			if count == 0 {
+				wave = append(wave, name)
+			}
+		}
+		if len(wave) == 0 {
+			for name := range dependants {
+				wave = append(wave, name)
+			}
+		}
+		sort.Strings(wave)
+		for _, name := range wave {
+			delete(dependants, name)
+			for _, dep := range dependencies[name] {
+				if _, ok := dependants[dep]; ok {
+					dependants[dep]--
+				}
+			}
+		}
+		waves = append(waves, wave)
+	}
+	return waves
+}
+
+// StopSummary lists the services that exited after being signalled, those
+// that were killed once their timeout passed, and those that could not be
+// stopped.
+type StopSummary struct {
+	Stopped []string
+	Killed  []string
+	Failed  map[string]error
+}
+
+func (summary *StopSummary) add(name string, killed bool, err error) {
+	switch {
+	case err != nil:
+		summary.Failed[name] = err
+	case killed:
+		summary.Killed = append(summary.Killed, name)
+	default:
+		summary.Stopped = append(summary.Stopped, name)
+	}
+}
+
+// Err combines the errors of the services that could not be stopped.
+func (summary StopSummary) Err() error {
+	var errs []error
+	for name, err := range summary.Failed {
+		errs = append(errs, fmt.Errorf("could not stop %s: %v", name, err))
+	}
+	return utils.CombineErrors(errs...)
+}
+
+func (summary StopSummary) write(writer io.Writer) {
+	sort.Strings(summary.Stopped)
+	sort.Strings(summary.Killed)
+	if len(summary.Stopped) != 0 {
+		fmt.Fprintf(writer, "Stopped: %s\n", strings.Join(summary.Stopped, ", "))
+	}
+	if len(summary.Killed) != 0 {
+		fmt.Fprintf(writer, "Killed after timeout: %s\n", strings.Join(summary.Killed, ", "))
+	}
+	var failed []string
+	for name := range summary.Failed {
+		failed = append(failed, name)
+	}
+	sort.Strings(failed)
+	for _, name := range failed {
+		fmt.Fprintf(writer, "Failed to stop %s: %v\n", name, summary.Failed[name])
+	}
+}
+
+// stopAll stops every running container and process in reverse dependency
+// order, stopping the services of each wave in parallel. Processes that have
+// already exited are only forgotten.
+func (app *App) stopAll(options RunOptions) StopSummary {
+	containers, processes := app.snapshot()
+	dependencies := map[string][]string{}
+	for name, proc := range containers {
+		if proc.Status == RUNNING {
+			dependencies[name] = proc.DependsOn
+		}
+	}
+	for name, proc := range processes {
+		if proc.Status == RUNNING {
+			dependencies[name] = proc.DependsOn
+		} else {
+			app.forgetProcess(name, proc)
+		}
+	}
+
+	var mu sync.Mutex
+	summary := StopSummary{Failed: map[string]error{}}
+	for _, wave := range stopOrder(dependencies) {
+		var wg sync.WaitGroup
+		for _, name := range wave {
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				var killed bool
+				var err error
+				if proc, ok := processes[name]; ok {
+					killed, err = app.stopProcess(name, proc, options.stopTimeout(proc))
+				} else {
+					proc := containers[name]
+					killed, err = app.stopContainer(name, proc, options.stopTimeout(proc))
+				}
+				mu.Lock()
+				summary.add(name, killed, err)
+				mu.Unlock()
+			}(name)
+		}
+		wg.Wait()
+	}
+	return summary
+}
+
+// stopContainer stops the container with its stop signal, killing it if it
+// has not exited within the timeout. It reports whether the container had to
+// be killed.
+func (app *App) stopContainer(name string, proc Process, timeout time.Duration) (bool, error) {
+	app.publish(ServiceStopping, name, proc.ID, "")
+	if proc.StopSignal == "" {
+		// docker sends SIGTERM, and SIGKILL once the timeout has passed
+		if err := app.cli.ContainerStop(context.Background(), proc.ID, &timeout); err != nil {
+			return false, err
+		}
+		killed := false
+		if inspect, err := app.cli.ContainerInspect(context.Background(), proc.ID); err == nil {
+			killed = inspect.State.ExitCode == exitCodeKilled
+		}
+		return killed, app.stopContainerInStore(name, proc, stopMessage(killed, timeout))
+	}
+
+	if err := app.cli.ContainerKill(context.Background(), proc.ID, proc.StopSignal); err != nil {
+		log.Println(err)
+	} else if app.waitContainer(proc.ID, timeout) {
+		if isKillSignal(proc.StopSignal) {
+			return true, app.stopContainerInStore(name, proc, killSignalMessage)
+		}
+		return false, app.stopContainerInStore(name, proc, "")
+	}
+	logging.Info(fmt.Sprintf("%s did not stop within %v, killing it", name, timeout))
+	if err := app.cli.ContainerKill(context.Background(), proc.ID, "SIGKILL"); err != nil {
+		return false, err
+	}
+	app.waitContainer(proc.ID, time.Second*10)
+	return true, app.stopContainerInStore(name, proc, stopMessage(true, timeout))
+}
+
+// waitContainer reports whether the container stopped within the timeout.
+func (app *App) waitContainer(id string, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	statusC, errC := app.cli.ContainerWait(ctx, id, container.WaitConditionNotRunning)
+	select {
+	case <-statusC:
+		return true
+	case err := <-errC:
+		return err != nil && ctx.Err() == nil
+	}
+}
+
+// stopProcess runs the on_stop command of the process, then signals its
+// process group, killing the group if it has not exited within the timeout.
+// It reports whether the process group had to be killed. A process that has
+// already exited is not signalled, as its pid may since have been reused by
+// an unrelated process.
+func (app *App) stopProcess(name string, proc Process, timeout time.Duration) (bool, error) {
+	if same, err := isSameProcess(proc); !same && err != errIdentityUnsupported {
+		app.publish(ServiceExited, name, proc.ID, "process exited")
+		app.forgetProcess(name, proc)
+		return false, nil
+	}
+	app.publish(ServiceStopping, name, proc.ID, "")
+	// the command is bounded by the timeout, so that it can't hold up the
+	// other services, and isn't run at all when stopping immediately
+	if proc.OnStop != "" && timeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		stop := strings.Fields(proc.OnStop)
+		if err := exec.CommandContext(ctx, stop[0], stop[1:]...).Run(); err != nil {
+			log.Printf("on_stop of %s failed: %v\n", name, err)
+		}
+		cancel()
+	}
+	if err := stopProcessGroup(proc); err != nil {
+		return false, err
+	}
+	pgid := proc.PGID
+	if pgid == 0 {
+		pgid = proc.PID
+	}
+	killed, message := isKillSignal(proc.StopSignal), ""
+	if killed {
+		message = killSignalMessage
+	}
+	if !waitProcessGroup(pgid, timeout) {
+		logging.Info(fmt.Sprintf("%s did not stop within %v, killing it", name, timeout))
+		if err := signalProcessGroup(pgid, "SIGKILL"); err != nil {
+			return false, err
+		}
+		killed, message = true, stopMessage(true, timeout)
+	}
+	app.publish(ServiceExited, name, proc.ID, message)
+	app.forgetProcess(name, proc)
+	return killed, nil
+}
+
+// forgetProcess removes a process which has exited from the state, along with
+// its secrets, configs and cgroup.
+func (app *App) forgetProcess(name string, proc Process) {
+	removeServiceFiles(proc.FilesDir)
+	removeProcessCgroup(app.project, name)
+	app.deleteProcess(name)
+}
+
+func stopMessage(killed bool, timeout time.Duration) string {
+	if killed {
+		return fmt.Sprintf("killed after %v", timeout)
+	}
+	return ""
+}
+
+// waitProcessGroup reports whether the process group exited within the
+// timeout.
+func waitProcessGroup(pgid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for processGroupAlive(pgid) {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	return true
+}
@@ -0,0 +1,68 @@
+package compose
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStopOrder(t *testing.T) {
+	tests := []struct {
+		name         string
+		dependencies map[string][]string
+		want         [][]string
+	}{
+		{name: "empty", dependencies: map[string][]string{}},
+		{
+			name:         "independent",
+			dependencies: map[string][]string{"a": nil, "b": nil},
+			want:         [][]string{{"a", "b"}},
+		},
+		{
+			name:         "chain",
+			dependencies: map[string][]string{"db": nil, "api": {"db"}, "web": {"api"}},
+			want:         [][]string{{"web"}, {"api"}, {"db"}},
+		},
+		{
+			name:         "shared dependency",
+			dependencies: map[string][]string{"db": nil, "api": {"db"}, "worker": {"db"}},
+			want:         [][]string{{"api", "worker"}, {"db"}},
+		},
+		{
+			name:         "dependency not running",
+			dependencies: map[string][]string{"api": {"db"}},
+			want:         [][]string{{"api"}},
+		},
+		{
+			name:         "cycle",
+			dependencies: map[string][]string{"a": {"b"}, "b": {"a"}, "web": {"a"}},
+			want:         [][]string{{"web"}, {"a", "b"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := stopOrder(test.dependencies); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("stopOrder(%v) = %v, expected %v", test.dependencies, got, test.want)
+			}
+		})
+	}
+}
+
+func TestIsKillSignal(t *testing.T) {
+	tests := []struct {
+		signal string
+		want   bool
+	}{
+		{"SIGKILL", true},
+		{"KILL", true},
+		{"sigkill", true},
+		{"9", true},
+		{"SIGTERM", false},
+		{"SIGINT", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if got := isKillSignal(test.signal); got != test.want {
+			t.Errorf("isKillSignal(%q) = %v, expected %v", test.signal, got, test.want)
+		}
+	}
+}
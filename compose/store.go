@@ -0,0 +1,69 @@
+package compose
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// StateStore persists the state of an App between runs of the server.
+type StateStore interface {
+	Load() (State, error)
+	Save(state State, operation string) error
+	Close() error
+}
+
+// HistoryStore is implemented by state stores that keep a record of every
+// operation that has been saved.
+type HistoryStore interface {
+	History(limit int) ([]HistoryEntry, error)
+}
+
+type HistoryEntry struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	State     State     `json:"state"`
+}
+
+func OpenStateStore(kind, dir string) (StateStore, error) {
+	switch kind {
+	case "", "json":
+		return OpenLockFile(dir)
+	case "bolt":
+		return OpenBoltStore(dir)
+	case "memory":
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown state store: %v (expected json, bolt or memory)", kind)
+	}
+}
+
+// MemoryStore keeps the state in memory only, and is mostly useful for tests
+// and throwaway environments.
+type MemoryStore struct {
+	mu    sync.Mutex
+	state State
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		state: NewState(),
+	}
+}
+
+func (store *MemoryStore) Load() (State, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.state, nil
+}
+
+func (store *MemoryStore) Save(state State, operation string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.state = state
+	return nil
+}
+
+func (store *MemoryStore) Close() error {
+	return nil
+}
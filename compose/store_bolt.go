@@ -0,0 +1,164 @@
+package compose
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	boltFileName = ".gompose.db"
+
+	// maxHistoryEntries is the number of saved states kept in the history,
+	// older entries are removed as new ones are saved.
+	maxHistoryEntries = 100
+)
+
+var (
+	stateBucket   = []byte("state")
+	historyBucket = []byte("history")
+	currentKey    = []byte("current")
+)
+
+// BoltStore keeps the current state in an embedded database, along with the
+// state saved after every operation.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func OpenBoltStore(dir string) (*BoltStore, error) {
+	path := filepath.Join(dir, boltFileName)
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err == bolt.ErrTimeout {
+		return nil, LockedError{Path: path, Err: err}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not open %s: %v", path, err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(stateBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(historyBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func (store *BoltStore) Load() (State, error) {
+	var data []byte
+	if err := store.db.View(func(tx *bolt.Tx) error {
+		data = append(data, tx.Bucket(stateBucket).Get(currentKey)...)
+		return nil
+	}); err != nil {
+		return State{}, err
+	}
+	if len(data) == 0 {
+		return NewState(), nil
+	}
+	return decodeState(data)
+}
+
+func (store *BoltStore) Save(state State, operation string) error {
+	state.Version = stateVersion
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	entry, err := json.Marshal(HistoryEntry{
+		Time:      time.Now(),
+		Operation: operation,
+		State:     state,
+	})
+	if err != nil {
+		return err
+	}
+	return store.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(stateBucket).Put(currentKey, data); err != nil {
+			return err
+		}
+		history := tx.Bucket(historyBucket)
+		seq, err := history.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		if err := history.Put(key, entry); err != nil {
+			return err
+		}
+		return pruneHistory(history, seq)
+	})
+}
+
+// pruneHistory removes the entries older than the last maxHistoryEntries,
+// given the sequence of the newest entry.
+func pruneHistory(history *bolt.Bucket, seq uint64) error {
+	if seq <= maxHistoryEntries {
+		return nil
+	}
+	oldest := make([]byte, 8)
+	binary.BigEndian.PutUint64(oldest, seq-maxHistoryEntries+1)
+	var keys [][]byte
+	cursor := history.Cursor()
+	for k, _ := cursor.First(); k != nil && bytes.Compare(k, oldest) < 0; k, _ = cursor.Next() {
+		keys = append(keys, append([]byte(nil), k...))
+	}
+	for _, key := range keys {
+		if err := history.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// History returns the most recent entries, newest first. A limit of zero
+// returns the entire history.
+func (store *BoltStore) History(limit int) ([]HistoryEntry, error) {
+	var entries []HistoryEntry
+	err := store.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(historyBucket).Cursor()
+		for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+			if limit > 0 && len(entries) == limit {
+				break
+			}
+			entry, err := decodeHistoryEntry(v)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// decodeHistoryEntry parses an entry of the history, migrating its state from
+// the version it was saved with.
+func decodeHistoryEntry(data []byte) (HistoryEntry, error) {
+	var raw struct {
+		Time      time.Time       `json:"time"`
+		Operation string          `json:"operation"`
+		State     json.RawMessage `json:"state"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return HistoryEntry{}, fmt.Errorf("could not parse history entry: %v", err)
+	}
+	state, err := decodeState(raw.State)
+	if err != nil {
+		return HistoryEntry{}, err
+	}
+	return HistoryEntry{Time: raw.Time, Operation: raw.Operation, State: state}, nil
+}
+
+func (store *BoltStore) Close() error {
+	return store.db.Close()
+}
@@ -2,6 +2,7 @@ package compose
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"github.com/docker/docker/pkg/jsonmessage"
 	"github.com/docker/docker/pkg/term"
@@ -15,6 +16,34 @@ func writeProcessPS(buffer *bytes.Buffer, processes map[string]Process) {
 	}
 }
 
+type processEntry struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Driver string `json:"driver"`
+	Status string `json:"status"`
+}
+
+type imageEntry struct {
+	Name   string `json:"name"`
+	Image  string `json:"image"`
+	Digest string `json:"digest"`
+}
+
+func writeProcessJSON(writer io.Writer, processes ...map[string]Process) error {
+	entries := []processEntry{}
+	for _, procs := range processes {
+		for name, proc := range procs {
+			entries = append(entries, processEntry{
+				ID:     proc.ID,
+				Name:   name,
+				Driver: proc.Driver.String(),
+				Status: proc.Status.String(),
+			})
+		}
+	}
+	return json.NewEncoder(writer).Encode(entries)
+}
+
 func limit(str string, length int) string {
 	if len(str) < length {
 		return str
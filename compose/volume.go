@@ -5,6 +5,23 @@ import (
 	"strings"
 )
 
+// VolumeConfig is a named volume declared at the top level of the definition.
+// External volumes are managed outside of gompose: they must exist when the
+// services are started, and are never created or removed.
+type VolumeConfig struct {
+	External bool   `yaml:"external"`
+	Name     string `yaml:"name"`
+}
+
+// GetName returns the name of the docker volume, which defaults to the key
+// of the volume in the definition.
+func (config VolumeConfig) GetName(key string) string {
+	if config.Name == "" {
+		return key
+	}
+	return config.Name
+}
+
 type Volume struct {
 	Source   string
 	Target   string
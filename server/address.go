@@ -0,0 +1,93 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const HostEnv = "GOMPOSE_HOST"
+
+// DefaultSocketPath returns the path of the unix socket that the server
+// listens on by default. It is placed in $XDG_RUNTIME_DIR, so that every user
+// can run their own server, falling back to a per-user temporary directory.
+func DefaultSocketPath() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "gompose.sock")
+	}
+	return filepath.Join(fallbackSocketDir(), "gompose.sock")
+}
+
+func fallbackSocketDir() string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("gompose-%d", os.Getuid()))
+}
+
+// DefaultHost returns the host that the CLI connects to, unless specified
+// with --host.
+func DefaultHost() string {
+	if host := os.Getenv(HostEnv); host != "" {
+		return host
+	}
+	return "unix://" + DefaultSocketPath()
+}
+
+// ParseHost splits a host of the form unix:///path/to/socket, tcp://host:port,
+// http(s)://host:port or host:port into the network and address to dial.
+func ParseHost(host string) (network string, address string, err error) {
+	switch {
+	case strings.HasPrefix(host, "unix://"):
+		network, address = "unix", strings.TrimPrefix(host, "unix://")
+	case strings.HasPrefix(host, "tcp://"):
+		network, address = "tcp", strings.TrimPrefix(host, "tcp://")
+	case strings.HasPrefix(host, "http://"):
+		network, address = "tcp", strings.TrimPrefix(host, "http://")
+	case strings.HasPrefix(host, "https://"):
+		network, address = "tcp", strings.TrimPrefix(host, "https://")
+	case strings.Contains(host, "://"):
+		return "", "", fmt.Errorf("unsupported host scheme: %v", host)
+	default:
+		network, address = "tcp", host
+	}
+	address = strings.TrimRight(address, "/")
+	if address == "" {
+		return "", "", fmt.Errorf("invalid host: %v", host)
+	}
+	return network, address, nil
+}
+
+// listenUnix listens on the socket, removing a stale socket left behind by a
+// server that did not shut down cleanly. The fallback directory in the shared
+// temporary directory must belong to the current user.
+func listenUnix(path string) (net.Listener, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if dir == fallbackSocketDir() {
+		if err := checkPrivateDir(dir); err != nil {
+			return nil, fmt.Errorf("refusing to listen on %s: %v", path, err)
+		}
+	}
+	if _, err := os.Stat(path); err == nil {
+		conn, err := net.DialTimeout("unix", path, time.Second)
+		if err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("a gompose server is already listening on %s", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := listenSocket(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
@@ -0,0 +1,39 @@
+package server
+
+import "testing"
+
+func TestParseHost(t *testing.T) {
+	tests := []struct {
+		host    string
+		network string
+		address string
+		err     bool
+	}{
+		{host: "unix:///run/gompose.sock", network: "unix", address: "/run/gompose.sock"},
+		{host: "tcp://localhost:2375", network: "tcp", address: "localhost:2375"},
+		{host: "tcp://localhost:2375/", network: "tcp", address: "localhost:2375"},
+		{host: "http://127.0.0.1:2375", network: "tcp", address: "127.0.0.1:2375"},
+		{host: "https://example.com:2376", network: "tcp", address: "example.com:2376"},
+		{host: "localhost:2375", network: "tcp", address: "localhost:2375"},
+		{host: "ssh://example.com", err: true},
+		{host: "unix://", err: true},
+		{host: "tcp://", err: true},
+		{host: "", err: true},
+	}
+	for _, test := range tests {
+		network, address, err := ParseHost(test.host)
+		if test.err {
+			if err == nil {
+				t.Errorf("ParseHost(%q) = %q, %q, expected an error", test.host, network, address)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseHost(%q) returned an error: %v", test.host, err)
+			continue
+		}
+		if network != test.network || address != test.address {
+			t.Errorf("ParseHost(%q) = %q, %q, expected %q, %q", test.host, network, address, test.network, test.address)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+//go:build !windows
+// +build !windows
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// checkPrivateDir verifies that the directory is owned by the current user
+// and inaccessible to anyone else, so that no other user can have created it
+// to take over the socket.
+func checkPrivateDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("%s is not owned by the current user", dir)
+	}
+	if info.Mode().Perm() != 0700 {
+		return fmt.Errorf("%s must only be accessible to its owner (mode 0700), got %o", dir, info.Mode().Perm())
+	}
+	return nil
+}
+
+// listenSocket listens on the unix socket, which is created accessible only
+// to the current user.
+func listenSocket(path string) (net.Listener, error) {
+	mask := syscall.Umask(0177)
+	defer syscall.Umask(mask)
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,22 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+func checkPrivateDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return nil
+}
+
+func listenSocket(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/Pungyeon/docker-gompose/compose"
+)
+
+// The exec and run endpoints upgrade the connection to a stream of frames,
+// each a type, a big endian length and the payload, carrying stdin and
+// terminal resizes from the client, and output and the exit code back.
+const (
+	FrameStdin byte = iota
+	FrameStdout
+	FrameStderr
+	FrameStdinClose
+	FrameResize
+	FrameExit
+	FrameError
+)
+
+// UpgradeProtocol is requested by clients upgrading to a stream of frames.
+const UpgradeProtocol = "gompose-stream"
+
+// MaxFrameSize is the largest payload of a frame. Larger writes are split
+// into several frames.
+const MaxFrameSize = 1 << 20
+
+func WriteFrame(w io.Writer, kind byte, payload []byte) error {
+	if len(payload) > MaxFrameSize {
+		return fmt.Errorf("frame of %d bytes exceeds the maximum of %d bytes", len(payload), MaxFrameSize)
+	}
+	header := make([]byte, 5)
+	header[0] = kind
+	binary.BigEndian.PutUint32(header[1:], uint32(len(payload)))
+	if _, err := w.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func ReadFrame(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > MaxFrameSize {
+		return 0, nil, fmt.Errorf("frame of %d bytes exceeds the maximum of %d bytes", size, MaxFrameSize)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+func EncodeResize(size compose.TerminalSize) []byte {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint16(payload, uint16(size.Height))
+	binary.BigEndian.PutUint16(payload[2:], uint16(size.Width))
+	return payload
+}
+
+func decodeResize(payload []byte) (compose.TerminalSize, bool) {
+	if len(payload) != 4 {
+		return compose.TerminalSize{}, false
+	}
+	return compose.TerminalSize{
+		Height: uint(binary.BigEndian.Uint16(payload)),
+		Width:  uint(binary.BigEndian.Uint16(payload[2:])),
+	}, true
+}
+
+// frameWriter writes everything written to it as frames of the given kind,
+// of at most MaxFrameSize bytes each.
+type frameWriter struct {
+	mu   *sync.Mutex
+	w    io.Writer
+	kind byte
+}
+
+func (writer frameWriter) Write(data []byte) (int, error) {
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+	written := 0
+	for written < len(data) {
+		end := written + MaxFrameSize
+		if end > len(data) {
+			end = len(data)
+		}
+		if err := WriteFrame(writer.w, writer.kind, data[written:end]); err != nil {
+			return written, err
+		}
+		written = end
+	}
+	return written, nil
+}
+
+type attachFunc func(ctx context.Context, definition compose.Definition, name string, options compose.ExecOptions, streams compose.Streams) (int, error)
+
+func getExecOptions(r *http.Request) compose.ExecOptions {
+	query := r.URL.Query()
+	tty, _ := strconv.ParseBool(query.Get("tty"))
+	stdin, _ := strconv.ParseBool(query.Get("stdin"))
+	remove, _ := strconv.ParseBool(query.Get("rm"))
+	return compose.ExecOptions{
+		Cmd:        query["arg"],
+		Tty:        tty,
+		Stdin:      stdin,
+		Env:        query["env"],
+		User:       query.Get("user"),
+		WorkingDir: query.Get("workdir"),
+		Remove:     remove,
+		Entrypoint: query.Get("entrypoint"),
+		Volumes:    query["volume"],
+	}
+}
+
+// exec runs a command in a service, or attaches to it, for the exec, run and
+// attach commands.
+func (server *Server) exec(w http.ResponseWriter, r *http.Request) {
+	app, err := server.app(r.URL.Query().Get("project"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	definition, err := getDefinitionFromBody(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var attach attachFunc
+	switch r.URL.Path {
+	case "/run":
+		attach = app.RunOnce
+	case "/attach":
+		attach = app.Attach
+	default:
+		attach = app.Exec
+	}
+	serveAttach(w, r, attach, definition, r.URL.Query().Get("service"), getExecOptions(r))
+}
+
+// serveAttach upgrades the connection and runs the command, until it exits or
+// the client disconnects.
+func serveAttach(w http.ResponseWriter, r *http.Request, attach attachFunc, definition compose.Definition, name string, options compose.ExecOptions) {
+	if r.Header.Get("Upgrade") != UpgradeProtocol {
+		http.Error(w, "expected an upgrade to "+UpgradeProtocol, http.StatusBadRequest)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "upgrading the connection is not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer conn.Close()
+	fmt.Fprintf(buf, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: %s\r\n\r\n", UpgradeProtocol)
+	if err := buf.Flush(); err != nil {
+		log.Println(err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stdin, stdinWriter := io.Pipe()
+	resize := make(chan compose.TerminalSize, 1)
+	go readClientFrames(ctx, cancel, buf.Reader, stdinWriter, resize)
+
+	mu := &sync.Mutex{}
+	code, err := attach(ctx, definition, name, options, compose.Streams{
+		Stdin:  stdin,
+		Stdout: frameWriter{mu: mu, w: conn, kind: FrameStdout},
+		Stderr: frameWriter{mu: mu, w: conn, kind: FrameStderr},
+		Resize: resize,
+	})
+	stdin.Close()
+	mu.Lock()
+	defer mu.Unlock()
+	if err != nil {
+		WriteFrame(conn, FrameError, []byte(err.Error()))
+		return
+	}
+	WriteFrame(conn, FrameExit, []byte(strconv.Itoa(code)))
+}
+
+// readClientFrames forwards stdin and resizes sent by the client, cancelling
+// the command when the client disconnects.
+func readClientFrames(ctx context.Context, cancel func(), r *bufio.Reader, stdin *io.PipeWriter, resize chan compose.TerminalSize) {
+	defer close(resize)
+	for {
+		kind, payload, err := ReadFrame(r)
+		if err != nil {
+			stdin.CloseWithError(err)
+			cancel()
+			return
+		}
+		switch kind {
+		case FrameStdin:
+			stdin.Write(payload)
+		case FrameStdinClose:
+			stdin.Close()
+		case FrameResize:
+			if size, ok := decodeResize(payload); ok {
+				select {
+				case resize <- size:
+				case <-ctx.Done():
+				}
+			}
+		}
+	}
+}
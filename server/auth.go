@@ -0,0 +1,276 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const TokenEnv = "GOMPOSE_TOKEN"
+
+// DefaultTokenFile is the file that the tokens accepted by the server are
+// kept in, relative to the working directory of the server.
+var DefaultTokenFile = filepath.Join(".gompose", "tokens.json")
+
+// Token is a named bearer token. Only the hash of the token is stored, the
+// token itself is shown once, when it is created.
+type Token struct {
+	Name    string    `json:"name"`
+	Hash    string    `json:"hash"`
+	Created time.Time `json:"created"`
+}
+
+// TokenStore manages the tokens kept in a file. The file is read again
+// whenever it changes, so that tokens can be created and revoked while the
+// server is running.
+type TokenStore struct {
+	path    string
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	tokens  []Token
+}
+
+func NewTokenStore(path string) *TokenStore {
+	return &TokenStore{path: path}
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (store *TokenStore) load() ([]Token, error) {
+	info, err := os.Stat(store.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.ModTime().Equal(store.modTime) && info.Size() == store.size {
+		return store.tokens, nil
+	}
+	data, err := ioutil.ReadFile(store.path)
+	if err != nil {
+		return nil, err
+	}
+	var tokens []Token
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("invalid token file %s: %v", store.path, err)
+	}
+	store.tokens = tokens
+	store.modTime = info.ModTime()
+	store.size = info.Size()
+	return tokens, nil
+}
+
+func (store *TokenStore) save(tokens []Token) error {
+	if err := os.MkdirAll(filepath.Dir(store.path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(store.path, data, 0600); err != nil {
+		return err
+	}
+	store.tokens = tokens
+	store.modTime = time.Time{}
+	return nil
+}
+
+// Verify returns the name of the token, if it is valid.
+func (store *TokenStore) Verify(token string) (string, bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	tokens, err := store.load()
+	if err != nil || token == "" {
+		return "", false
+	}
+	hash := []byte(hashToken(token))
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare(hash, []byte(t.Hash)) == 1 {
+			return t.Name, true
+		}
+	}
+	return "", false
+}
+
+// Create generates a new token with the given name, returning the token.
+func (store *TokenStore) Create(name string) (string, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	tokens, err := store.load()
+	if err != nil {
+		return "", err
+	}
+	for _, t := range tokens {
+		if t.Name == name {
+			return "", fmt.Errorf("token already exists: %v", name)
+		}
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(secret)
+	tokens = append(tokens, Token{
+		Name:    name,
+		Hash:    hashToken(token),
+		Created: time.Now().UTC(),
+	})
+	return token, store.save(tokens)
+}
+
+// Revoke removes the token with the given name.
+func (store *TokenStore) Revoke(name string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	tokens, err := store.load()
+	if err != nil {
+		return err
+	}
+	for i, t := range tokens {
+		if t.Name == name {
+			return store.save(append(tokens[:i:i], tokens[i+1:]...))
+		}
+	}
+	return fmt.Errorf("no such token: %v", name)
+}
+
+func (store *TokenStore) List() ([]Token, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	tokens, err := store.load()
+	if err != nil {
+		return nil, err
+	}
+	list := append([]Token{}, tokens...)
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list, nil
+}
+
+type connKey struct{}
+
+// withConn stores the connection of a request in its context, so that the
+// handlers can tell whether it arrived on the unix socket.
+func withConn(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, conn)
+}
+
+func isUnixConn(r *http.Request) bool {
+	conn, ok := r.Context().Value(connKey{}).(net.Conn)
+	return ok && conn.LocalAddr().Network() == "unix"
+}
+
+// authenticate requires a valid bearer token on requests arriving over TCP.
+// The unix socket is only accessible to the user running the server, so
+// requests on it are trusted.
+func (server *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if server.tokens == nil || isUnixConn(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		header := r.Header.Get("Authorization")
+		if !strings.HasPrefix(header, "Bearer ") {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gompose"`)
+			http.Error(w, "missing bearer token", http.StatusUnauthorized)
+			return
+		}
+		if _, ok := server.tokens.Verify(strings.TrimPrefix(header, "Bearer ")); !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gompose", error="invalid_token"`)
+			http.Error(w, "invalid bearer token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// TLSOptions configures TLS on the TCP listener. When ClientCA is set, clients
+// must present a certificate signed by it.
+type TLSOptions struct {
+	Cert     string
+	Key      string
+	ClientCA string
+}
+
+func (options TLSOptions) Enabled() bool {
+	return options.Cert != "" || options.Key != ""
+}
+
+func (options TLSOptions) config() (*tls.Config, error) {
+	if options.Cert == "" || options.Key == "" {
+		return nil, fmt.Errorf("both a TLS certificate and key are required")
+	}
+	cert, err := tls.LoadX509KeyPair(options.Cert, options.Key)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if options.ClientCA != "" {
+		pool, err := loadCertPool(options.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// ClientTLSConfig returns the TLS configuration of a client verifying the
+// server with the given CA, and authenticating with the given certificate.
+// The system roots are used when no CA is given.
+func ClientTLSConfig(ca, cert, key string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if ca != "" {
+		pool, err := loadCertPool(ca)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	if cert != "" || key != "" {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	return config, nil
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// RotatingFile is a log file that is rotated once it grows beyond MaxSize,
+// keeping up to Backups old files named <path>.1 to <path>.N.
+type RotatingFile struct {
+	path    string
+	maxSize int64
+	backups int
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	// stderr is redirected to the current file, when captured.
+	stderr bool
+}
+
+func OpenRotatingFile(path string, maxSize int64, backups int) (*RotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	file := &RotatingFile{
+		path:    path,
+		maxSize: maxSize,
+		backups: backups,
+	}
+	if err := file.open(); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (file *RotatingFile) open() error {
+	f, err := os.OpenFile(file.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	file.file = f
+	file.size = info.Size()
+	if file.stderr {
+		return redirectStderr(f)
+	}
+	return nil
+}
+
+// CaptureStderr redirects the standard error of the process to the file, and
+// to the new file after every rotation, so that panics are logged as well.
+func (file *RotatingFile) CaptureStderr() error {
+	file.mu.Lock()
+	defer file.mu.Unlock()
+	file.stderr = true
+	return redirectStderr(file.file)
+}
+
+// rotate renames the file to its first backup and opens a new file. When the
+// file cannot be rotated, it is opened again so that logging continues in it,
+// and the error is written to it.
+func (file *RotatingFile) rotate() error {
+	err := file.file.Close()
+	if err == nil {
+		err = file.shift()
+	}
+	if err == nil {
+		return file.open()
+	}
+	if openErr := file.open(); openErr != nil {
+		return openErr
+	}
+	fmt.Fprintf(file.file, "could not rotate log file %s: %v\n", file.path, err)
+	// rotation is attempted again once another maxSize has been written
+	file.size = 0
+	return nil
+}
+
+func (file *RotatingFile) shift() error {
+	for i := file.backups - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", file.path, i), fmt.Sprintf("%s.%d", file.path, i+1))
+	}
+	if file.backups > 0 {
+		if err := os.Rename(file.path, file.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(file.path); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (file *RotatingFile) Write(data []byte) (int, error) {
+	file.mu.Lock()
+	defer file.mu.Unlock()
+	if file.maxSize > 0 && file.size > 0 && file.size+int64(len(data)) > file.maxSize {
+		if err := file.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := file.file.Write(data)
+	file.size += int64(n)
+	return n, err
+}
+
+func (file *RotatingFile) Close() error {
+	file.mu.Lock()
+	defer file.mu.Unlock()
+	return file.file.Close()
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package server
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func redirectStderr(file *os.File) error {
+	return unix.Dup2(int(file.Fd()), int(os.Stderr.Fd()))
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ReadPidFile returns the pid in the file, if the process is still running.
+func ReadPidFile(path string) (int, bool) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, false
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return 0, false
+	}
+	if err := process.Signal(syscall.Signal(0)); err != nil {
+		return 0, false
+	}
+	return pid, true
+}
+
+// writePidFile writes the pid of the server, refusing to overwrite the pid
+// file of another server that is still running.
+func writePidFile(path string) error {
+	if pid, ok := ReadPidFile(path); ok && pid != os.Getpid() {
+		return fmt.Errorf("a gompose server is already running with pid %d", pid)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0600)
+}
@@ -1,55 +1,470 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/Pungyeon/docker-gompose/compose"
 	"github.com/Pungyeon/docker-gompose/utils"
 	"gopkg.in/yaml.v2"
 )
 
+var projectName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// shutdownTimeout is how long the server waits for requests in flight to
+// complete when shutting down.
+const shutdownTimeout = time.Second * 30
+
+// Version is the version of gompose, set at build time with
+// -ldflags "-X github.com/Pungyeon/docker-gompose/server.Version=...".
+var Version = "dev"
+
 type Server struct {
-	app *compose.App
+	options Options
+	mu      sync.Mutex
+	apps    map[string]*compose.App
+	http    *http.Server
+	tokens  *TokenStore
+	stop    chan bool
+	started time.Time
 }
 
-func New() (*Server, error) {
-	if err := os.Setenv("DOCKER_API_VERSION", "1.40"); err != nil {
+type Options struct {
+	StateStore string
+	// Address is the TCP address to listen on, if any.
+	Address string
+	// Socket is the path of the unix socket to listen on, if any.
+	Socket string
+	// TLS configures TLS, and optionally client certificates, on the TCP
+	// address.
+	TLS TLSOptions
+	// Auth requires a bearer token from TokenFile on requests over TCP.
+	Auth      bool
+	TokenFile string
+	// Insecure allows serving the API over TCP without authenticating the
+	// clients, with either Auth or client certificates.
+	Insecure bool
+	// DisableExec refuses to start services using the EXEC driver.
+	DisableExec bool
+	// StopOnExit stops all services when the server shuts down, rather than
+	// leaving them running to be adopted by the next server.
+	StopOnExit bool
+	// PidFile is written with the pid of the server while it is running.
+	PidFile string
+}
+
+type Status struct {
+	Version  string          `json:"version"`
+	Pid      int             `json:"pid"`
+	Started  time.Time       `json:"started"`
+	Uptime   string          `json:"uptime"`
+	Projects []ProjectStatus `json:"projects"`
+}
+
+type ProjectStatus struct {
+	Name       string `json:"name"`
+	Operation  string `json:"operation,omitempty"`
+	Pending    int    `json:"pending"`
+	Containers int    `json:"containers"`
+	Processes  int    `json:"processes"`
+}
+
+func New(options Options) (*Server, error) {
+	server := &Server{
+		options: options,
+		apps:    map[string]*compose.App{},
+		stop:    make(chan bool, 1),
+		started: time.Now(),
+	}
+	if options.Auth {
+		if options.TokenFile == "" {
+			options.TokenFile = DefaultTokenFile
+		}
+		server.tokens = NewTokenStore(options.TokenFile)
+	}
+	if _, err := server.app(compose.DefaultProject); err != nil {
 		return nil, err
 	}
-	app, err := compose.NewApp()
+	return server, nil
+}
+
+// app returns the App managing the given project, loading its state on first
+// use.
+func (server *Server) app(project string) (*compose.App, error) {
+	if project == "" {
+		project = compose.DefaultProject
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if app, ok := server.apps[project]; ok {
+		return app, nil
+	}
+	app, err := OpenApp(project, server.options)
 	if err != nil {
 		return nil, err
 	}
 	app.Monitor()
+	server.apps[project] = app
+	return app, nil
+}
 
-	return &Server{
-		app: app,
-	}, nil
+// OpenApp loads the state of the project, locking it for as long as the App
+// is open. The default project keeps its state in the working directory,
+// whereas other projects are kept in their own directory.
+func OpenApp(project string, options Options) (*compose.App, error) {
+	if !projectName.MatchString(project) {
+		return nil, fmt.Errorf("invalid project name: %v", project)
+	}
+	if err := os.Setenv("DOCKER_API_VERSION", "1.40"); err != nil {
+		return nil, err
+	}
+	dir := "."
+	if project != compose.DefaultProject {
+		dir = filepath.Join(".gompose", "projects", project)
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+	}
+	store, err := compose.OpenStateStore(options.StateStore, dir)
+	if err != nil {
+		return nil, err
+	}
+	app, err := compose.NewApp(project, store)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	if options.DisableExec {
+		app.DisableExec()
+	}
+	return app, nil
 }
 
 func (server *Server) Start() error {
-	http.HandleFunc("/config", server.config)
-	return http.ListenAndServe(":8080", nil)
+	listeners, err := server.listen()
+	if err != nil {
+		return err
+	}
+	if server.options.PidFile != "" {
+		if err := writePidFile(server.options.PidFile); err != nil {
+			for _, listener := range listeners {
+				listener.Close()
+			}
+			return err
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/config", server.config)
+	mux.HandleFunc("/events", server.streamEvents)
+	mux.HandleFunc("/logs", server.logs)
+	mux.HandleFunc("/status", server.status)
+	mux.HandleFunc("/shutdown", server.shutdownHandler)
+	mux.HandleFunc("/exec", server.exec)
+	mux.HandleFunc("/run", server.exec)
+	mux.HandleFunc("/attach", server.exec)
+
+	// streaming requests never complete by themselves, so they are cancelled
+	// when shutting down, rather than waited for.
+	ctx, cancel := context.WithCancel(context.Background())
+	server.http = &http.Server{
+		Handler:     server.authenticate(mux),
+		ConnContext: withConn,
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
+	}
+	server.http.RegisterOnShutdown(cancel)
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	errs := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		log.Printf("listening on %s://%s\n", listener.Addr().Network(), listener.Addr())
+		go func(listener net.Listener) {
+			errs <- server.http.Serve(listener)
+		}(listener)
+	}
+
+	stop := server.options.StopOnExit
+	select {
+	case err := <-errs:
+		return utils.CombineErrors(err, server.shutdown(stop))
+	case sig := <-signals:
+		log.Printf("received %v, shutting down\n", sig)
+	case requested := <-server.stop:
+		log.Println("shutdown requested")
+		stop = stop || requested
+	}
+	go func() {
+		<-signals
+		log.Println("forcing shutdown")
+		os.Exit(1)
+	}()
+	return server.shutdown(stop)
+}
+
+// shutdown stops accepting requests, waits for the requests in flight to
+// complete, and then shuts down every project.
+func (server *Server) shutdown(stop bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	var errs []error
+	if err := server.http.Shutdown(ctx); err != nil {
+		errs = append(errs, err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	for name, app := range server.apps {
+		if err := app.Shutdown(stop); err != nil {
+			errs = append(errs, fmt.Errorf("project %s: %v", name, err))
+		}
+		delete(server.apps, name)
+	}
+	if server.options.Socket != "" {
+		os.Remove(server.options.Socket)
+	}
+	if server.options.PidFile != "" {
+		os.Remove(server.options.PidFile)
+	}
+	return utils.CombineErrors(errs...)
+}
+
+func (server *Server) shutdownHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	stop, _ := strconv.ParseBool(r.URL.Query().Get("stop"))
+	select {
+	case server.stop <- stop:
+	default:
+	}
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintln(w, "shutting down")
+}
+
+func (server *Server) listen() ([]net.Listener, error) {
+	var listeners []net.Listener
+	if server.options.Socket != "" {
+		listener, err := listenUnix(server.options.Socket)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+	if server.options.Address != "" {
+		listener, err := server.listenTCP()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+	if len(listeners) == 0 {
+		return nil, fmt.Errorf("no address or socket to listen on")
+	}
+	return listeners, nil
+}
+
+// listenTCP listens on the TCP address. The API runs commands on the host, so
+// clients must be authenticated, with a bearer token or a client certificate,
+// unless Insecure is set.
+func (server *Server) listenTCP() (net.Listener, error) {
+	authenticated := server.options.Auth || server.options.TLS.Enabled() && server.options.TLS.ClientCA != ""
+	if !authenticated && !server.options.Insecure {
+		return nil, fmt.Errorf("refusing to serve the API on %s without authenticating clients, use --auth or --tls-ca to require it, or --insecure to allow it", server.options.Address)
+	}
+	var config *tls.Config
+	if server.options.TLS.Enabled() {
+		c, err := server.options.TLS.config()
+		if err != nil {
+			return nil, err
+		}
+		config = c
+	}
+	listener, err := net.Listen("tcp", server.options.Address)
+	if err != nil {
+		return nil, err
+	}
+	if !authenticated {
+		log.Printf("warning: the API on %s accepts unauthenticated requests\n", listener.Addr())
+	}
+	if config == nil {
+		log.Printf("warning: the API on %s is served without TLS\n", listener.Addr())
+		return listener, nil
+	}
+	return tls.NewListener(listener, config), nil
 }
 
 func (server *Server) config(w http.ResponseWriter, r *http.Request) {
 	cmd := r.URL.Query().Get("cmd")
+	app, err := server.app(r.URL.Query().Get("project"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	options, err := getRunOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	definition, err := getDefinitionFromBody(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("Accept") == ndjsonContentType {
+		runStreaming(app, w, cmd, definition, options)
+		return
 	}
-	if err := utils.HandleErrors(utils.LogErrors,
-		server.app.RunWithDefinition(cmd, definition, w),
-		server.app.Wait(),
-		server.app.Save(),
-	); err != nil {
+	if err := app.RunWithDefinition(cmd, definition, options, w); err != nil {
 		log.Println(err)
 	}
 }
 
+// streamEvents sends every lifecycle event to the client as server-sent
+// events, until the client disconnects.
+func (server *Server) streamEvents(w http.ResponseWriter, r *http.Request) {
+	app, err := server.app(r.URL.Query().Get("project"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	events, unsubscribe := app.Events().Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-events:
+			data, err := json.Marshal(event)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			flusher.Flush()
+		}
+	}
+}
+
+func (server *Server) logs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	app, err := server.app(query.Get("project"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	follow, _ := strconv.ParseBool(query.Get("follow"))
+	showSecrets, _ := strconv.ParseBool(query.Get("show-secrets"))
+	w.Header().Set("Content-Type", "text/plain")
+	if err := app.Logs(r.Context(), query.Get("service"), compose.LogOptions{
+		Follow:      follow,
+		Tail:        query.Get("tail"),
+		ShowSecrets: showSecrets,
+	}, flushWriter{w}); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+func (server *Server) status(w http.ResponseWriter, r *http.Request) {
+	server.mu.Lock()
+	status := Status{
+		Version: Version,
+		Pid:     os.Getpid(),
+		Started: server.started,
+		Uptime:  time.Since(server.started).Round(time.Second).String(),
+	}
+	for name, app := range server.apps {
+		state := app.State()
+		operation, pending := app.CurrentOperation()
+		status.Projects = append(status.Projects, ProjectStatus{
+			Name:       name,
+			Operation:  operation,
+			Pending:    pending,
+			Containers: len(state.Containers),
+			Processes:  len(state.Processes),
+		})
+	}
+	server.mu.Unlock()
+	sort.Slice(status.Projects, func(i, j int) bool {
+		return status.Projects[i].Name < status.Projects[j].Name
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		log.Println(err)
+	}
+}
+
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (writer flushWriter) Write(data []byte) (int, error) {
+	n, err := writer.w.Write(data)
+	if flusher, ok := writer.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return n, err
+}
+
+func getRunOptions(r *http.Request) (compose.RunOptions, error) {
+	query := r.URL.Query()
+	removeVolumes, _ := strconv.ParseBool(query.Get("volumes"))
+	removeOrphans, _ := strconv.ParseBool(query.Get("remove-orphans"))
+	options := compose.RunOptions{
+		Format:        query.Get("format"),
+		RemoveVolumes: removeVolumes,
+		RemoveOrphans: removeOrphans,
+		RemoveImages:  query.Get("rmi"),
+		Profiles:      query["profile"],
+	}
+	if timeout := query.Get("timeout"); timeout != "" {
+		// a bare integer is a number of seconds, as with docker-compose
+		duration, err := time.ParseDuration(timeout)
+		if seconds, atoiErr := strconv.Atoi(timeout); atoiErr == nil {
+			duration, err = time.Duration(seconds)*time.Second, nil
+		}
+		if err != nil {
+			return compose.RunOptions{}, fmt.Errorf("invalid timeout: %v", err)
+		}
+		options.Timeout = &duration
+	}
+	return options, options.Validate()
+}
+
 func getDefinitionFromBody(r *http.Request) (compose.Definition, error) {
 	data, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -63,6 +478,9 @@ func getDefinitionFromBody(r *http.Request) (compose.Definition, error) {
 	if err := yaml.Unmarshal(data, &definition); err != nil {
 		return compose.Definition{}, err
 	}
+	// clients of the API may not have validated the definition
+	if err := definition.Validate(); err != nil {
+		return compose.Definition{}, err
+	}
 	return definition, nil
 }
-
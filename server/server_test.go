@@ -0,0 +1,35 @@
+package server
+
+import "testing"
+
+func TestListenTCPRequiresAuthentication(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+		refused bool
+	}{
+		{name: "plain", options: Options{}, refused: true},
+		{name: "TLS without client authentication", options: Options{TLS: TLSOptions{Cert: "cert.pem", Key: "key.pem"}}, refused: true},
+		{name: "client CA without TLS", options: Options{TLS: TLSOptions{ClientCA: "ca.pem"}}, refused: true},
+		{name: "auth", options: Options{Auth: true}},
+		{name: "insecure", options: Options{Insecure: true}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.options.Address = "127.0.0.1:0"
+			server := &Server{options: test.options}
+			listener, err := server.listenTCP()
+			if test.refused {
+				if err == nil {
+					listener.Close()
+					t.Fatal("expected the listener to be refused")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			listener.Close()
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/Pungyeon/docker-gompose/compose"
+)
+
+const ndjsonContentType = "application/x-ndjson"
+
+// StreamMessage is a single line of a streamed command response. Exactly one
+// of the fields is set: progress events while the command runs, output
+// written by the command, and finally an error if the command failed.
+type StreamMessage struct {
+	Event  *compose.LifecycleEvent `json:"event,omitempty"`
+	Output string                  `json:"output,omitempty"`
+	Error  string                  `json:"error,omitempty"`
+}
+
+// streamWriter encodes command output and events as newline delimited JSON,
+// flushing every message so that the client can render it immediately.
+type streamWriter struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+	flusher http.Flusher
+}
+
+func newStreamWriter(w http.ResponseWriter) (*streamWriter, bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, false
+	}
+	w.Header().Set("Content-Type", ndjsonContentType)
+	w.WriteHeader(http.StatusOK)
+	return &streamWriter{
+		encoder: json.NewEncoder(w),
+		flusher: flusher,
+	}, true
+}
+
+func (stream *streamWriter) Write(data []byte) (int, error) {
+	if err := stream.send(StreamMessage{Output: string(data)}); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func (stream *streamWriter) send(msg StreamMessage) error {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	if err := stream.encoder.Encode(msg); err != nil {
+		return err
+	}
+	stream.flusher.Flush()
+	return nil
+}
+
+// runStreaming executes the command, forwarding the lifecycle events published
+// by it to the client. Events of the commands of other clients are left out.
+func runStreaming(app *compose.App, w http.ResponseWriter, cmd string, definition compose.Definition, options compose.RunOptions) {
+	stream, ok := newStreamWriter(w)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	options.Operation = app.NewOperation()
+	events, unsubscribe := app.Events().Subscribe()
+	forwarded := make(chan struct{})
+	go func() {
+		defer close(forwarded)
+		for event := range events {
+			if event.Operation != options.Operation {
+				continue
+			}
+			event := event
+			stream.send(StreamMessage{Event: &event})
+		}
+	}()
+
+	err := app.RunWithDefinition(cmd, definition, options, stream)
+	unsubscribe()
+	<-forwarded
+	if err != nil {
+		stream.send(StreamMessage{Error: err.Error()})
+	}
+}
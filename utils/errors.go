@@ -1,6 +1,9 @@
 package utils
 
-import "log"
+import (
+	"log"
+	"strings"
+)
 
 func ReturnError(errs ...error) error {
 	for _, err := range errs {
@@ -22,4 +25,34 @@ func LogErrors(errs ...error) error {
 
 func HandleErrors(f func(...error) error, errs ...error) error {
 	return f(errs...)
-}
\ No newline at end of file
+}
+
+type MultiError []error
+
+func (errs MultiError) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// CombineErrors collects every non-nil error into a MultiError, returning nil
+// if none of the given errors are set.
+func CombineErrors(errs ...error) error {
+	var combined MultiError
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		if multi, ok := err.(MultiError); ok {
+			combined = append(combined, multi...)
+			continue
+		}
+		combined = append(combined, err)
+	}
+	if len(combined) == 0 {
+		return nil
+	}
+	return combined
+}